@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/analyzer"
+	"github.com/kadaan/promutil/lib/command"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"analyze",
+		"Analyze prometheus TSDB",
+		"Analyze the cardinality, churn, and samples per series of the blocks in a local prometheus TSDB.",
+		new(config.AnalyzeConfig),
+		analyzer.NewAnalyzer()).Configure(func(fb config.FlagBuilder, cfg *config.AnalyzeConfig) {
+		fb.Directory(&cfg.Directory, "directory to read TSDB data")
+		fb.Matchers(&cfg.Matchers, "matcher selecting the series to analyze, all series when not specified")
+		fb.ReportFormat(&cfg.Format, "format of the report, one of text, json or csv")
+		fb.Limit(&cfg.Limit, "number of top metrics, label names, and label values to report, unlimited when zero")
+	})
+}
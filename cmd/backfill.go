@@ -21,6 +21,8 @@ func init() {
 		fb.RecordingRules(&cfg.RuleConfig, "config file defining the rules to evaluate")
 		fb.RuleGroupFilters(&cfg.RuleGroupFilters, "rule group filters which determine the rules groups to backfill")
 		fb.RuleNameFilters(&cfg.RuleNameFilters, "rule name filters which determine the rules groups to backfill")
+		fb.RemoteWriteConfig(&cfg.RemoteWriteConfig, "config file defining the remote write endpoint to send samples to instead of writing TSDB blocks")
 		fb.Parallelism(&cfg.Parallelism, block.MaxParallelism, "parallelism for backfill")
+		fb.BlockWriter(&cfg.BlockWriter)
 	})
 }
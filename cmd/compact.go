@@ -15,5 +15,6 @@ func init() {
 		new(config.CompactConfig),
 		compactor.NewCompactor()).Configure(func(fb config.FlagBuilder, cfg *config.CompactConfig) {
 		fb.Directory(&cfg.Directory, "directory read and write TSDB data")
+		fb.BlockRanges(&cfg.MinBlockDuration, &cfg.MaxBlockDuration, &cfg.MaxBlockSize)
 	})
 }
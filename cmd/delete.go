@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/deleter"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"delete",
+		"Delete prometheus data",
+		"Delete the series matching the specified matchers within a time range from a local prometheus TSDB.",
+		new(config.DeleteConfig),
+		deleter.NewDeleter()).Configure(func(fb config.FlagBuilder, cfg *config.DeleteConfig) {
+		fb.Directory(&cfg.Directory, "directory read and write TSDB data")
+		fb.TimeRange(&cfg.Start, &cfg.End, "time to delete data")
+		fb.Matchers(&cfg.Matchers, "matcher selecting the series to delete").Required()
+		fb.CleanTombstones(&cfg.CleanTombstones, "rewrite the affected blocks to physically remove the deleted data")
+		fb.DryRun(&cfg.DryRun, "report the series and samples which would be deleted without deleting them")
+	})
+}
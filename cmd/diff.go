@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/differ"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"diff",
+		"Diff prometheus data",
+		"Compare the specified series of two local prometheus TSDBs or remote prometheus hosts, and report the series and samples which differ.",
+		new(config.DiffConfig),
+		differ.NewDiffer()).Configure(func(fb config.FlagBuilder, cfg *config.DiffConfig) {
+		fb.TimeRange(&cfg.Start, &cfg.End, "time to compare")
+		fb.Matchers(&cfg.Matchers, "series selector of the series to compare, all series are compared when not specified")
+		fb.Tolerance(&cfg.Tolerance, "maximum difference between values relative to the larger value, values must be equal when zero")
+		fb.OutputFile(&cfg.OutputFile, "file to write the detailed JSON report to")
+		fb.Host(&cfg.Host, "remote host to read the source data from using remote read")
+		fb.SourceDirectory(&cfg.SourceDirectory, "local TSDB directory or snapshot to read the source data from instead of the remote host")
+		fb.TargetHost(&cfg.TargetHost, "remote host to read the target data from using remote read")
+		fb.TargetDirectory(&cfg.TargetDirectory, "local TSDB directory or snapshot to read the target data from instead of the remote host")
+		fb.HTTPConfig(&cfg.HTTPConfig, "config file defining the http client configuration used to connect to the remote hosts")
+		fb.RateLimit(&cfg.RateLimit)
+	})
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/downsampler"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"downsample",
+		"Downsample prometheus data",
+		"Downsample the specified series of a local prometheus TSDB into blocks of series holding the min, max, sum, count and counter rate of each resolution window.",
+		new(config.DownsampleConfig),
+		downsampler.NewDownsampler()).Configure(func(fb config.FlagBuilder, cfg *config.DownsampleConfig) {
+		fb.TimeRange(&cfg.Start, &cfg.End, "time to downsample")
+		fb.SourceDirectory(&cfg.SourceDirectory, "local TSDB directory or snapshot to downsample data from")
+		fb.OutputDirectory(&cfg.OutputDirectory, "directory write TSDB data")
+		fb.Matchers(&cfg.Matchers, "series selector of the series to downsample, all series are downsampled when not specified")
+		fb.Resolution(&cfg.Resolution, "duration of the windows the samples are aggregated over")
+		fb.Aggregations(&cfg.Aggregations, "aggregations written for each window (min, max, sum, count or rate), rate is only written for counters")
+		fb.RemoteWriteConfig(&cfg.RemoteWriteConfig, "config file defining the remote write endpoint to send samples to instead of writing TSDB blocks")
+		fb.Parallelism(&cfg.Parallelism, 4, "parallelism for downsampling")
+		fb.BlockWriter(&cfg.BlockWriter)
+	})
+}
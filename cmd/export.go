@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/exporter"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"export",
+		"Export prometheus data",
+		"Export the specified series from a local prometheus TSDB or remote prometheus as OpenMetrics text, CSV or JSON lines.",
+		new(config.ExportConfig),
+		exporter.NewExporter()).Configure(func(fb config.FlagBuilder, cfg *config.ExportConfig) {
+		fb.TimeRange(&cfg.Start, &cfg.End, "time to export")
+		fb.Matchers(&cfg.Matchers, "series selector of the series to export, all series are exported when not specified")
+		fb.ExportFormat(&cfg.Format, "format of the exported data (openmetrics, csv, csv-wide or jsonl)")
+		fb.Step(&cfg.Step, "step onto which the samples are resampled, samples are exported as they are when zero")
+		fb.OutputFile(&cfg.OutputFile, "file to write the exported data to, data is written to stdout when not specified")
+		fb.Host(&cfg.Host, "remote host to export data from using remote read")
+		fb.HTTPConfig(&cfg.HTTPConfig, "config file defining the http client configuration used to connect to the remote host")
+		fb.RateLimit(&cfg.RateLimit)
+		fb.SourceDirectory(&cfg.SourceDirectory, "local TSDB directory or snapshot to export data from instead of the remote host")
+	})
+}
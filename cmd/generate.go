@@ -20,6 +20,8 @@ func init() {
 		fb.SampleInterval(&cfg.SampleInterval, "interval at which samples will be generated")
 		fb.MetricConfig(&cfg.MetricConfig, "config file defining the time series to create").Required()
 		fb.RecordingRules(&cfg.RuleConfig, "config file defining the rules to evaluate")
+		fb.RemoteWriteConfig(&cfg.RemoteWriteConfig, "config file defining the remote write endpoint to send samples to instead of writing TSDB blocks")
 		fb.Parallelism(&cfg.Parallelism, block.MaxParallelism, "parallelism for generation")
+		fb.BlockWriter(&cfg.BlockWriter)
 	})
 }
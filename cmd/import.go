@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/block"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/importer"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"import",
+		"Import prometheus data",
+		"Import samples from OpenMetrics text, CSV or JSON lines files to a local prometheus TSDB.",
+		new(config.ImportConfig),
+		importer.NewImporter()).Configure(func(fb config.FlagBuilder, cfg *config.ImportConfig) {
+		fb.InputFiles(&cfg.InputFiles, "file to import samples from, or - to read from stdin").Required()
+		fb.ImportFormat(&cfg.Format, "format of the input files (openmetrics, csv or jsonl)")
+		fb.CSVMapping(&cfg.CSVMapping, "config file defining how the columns of CSV input map onto series and samples")
+		fb.ErrorReportFile(&cfg.ErrorReportFile, "file to write the rejected lines to as CSV")
+		fb.OutputDirectory(&cfg.OutputDirectory, "output directory to write TSDB data")
+		fb.RemoteWriteConfig(&cfg.RemoteWriteConfig, "config file defining the remote write endpoint to send samples to instead of writing TSDB blocks")
+		fb.Parallelism(&cfg.Parallelism, block.MaxParallelism, "parallelism for import")
+		fb.BlockWriter(&cfg.BlockWriter)
+	})
+}
@@ -11,14 +11,23 @@ func init() {
 		Root,
 		"migrate",
 		"Migrate prometheus data",
-		"Migrate the specified data from a remote prometheus to a local prometheus TSDB.",
+		"Migrate the specified data from a remote prometheus or local TSDB directory to a local prometheus TSDB.",
 		new(config.MigrateConfig),
 		migrator.NewMigrator()).Configure(func(fb config.FlagBuilder, cfg *config.MigrateConfig) {
 		fb.TimeRange(&cfg.Start, &cfg.End, "time to migrate")
 		fb.OutputDirectory(&cfg.OutputDirectory, "directory write TSDB data")
 		fb.SampleInterval(&cfg.SampleInterval, "interval at which samples will be migrated")
 		fb.Matchers(&cfg.Matchers, "config file defining the rules to evaluate")
+		fb.MaxSeriesPerRequest(&cfg.MaxSeriesPerRequest, "maximum number of series read per request, enables series discovery and batching when non-zero")
+		fb.DedupLabels(&cfg.DedupLabels, "label identifying HA replicas, series differing only by this label are merged")
+		fb.RelabelConfig(&cfg.RelabelConfig, "config file defining the relabeling to apply to migrated series")
+		fb.RemoteWriteConfig(&cfg.RemoteWriteConfig, "config file defining the remote write endpoint to send samples to instead of writing TSDB blocks")
 		fb.Host(&cfg.Host, "remote host to migrate data from")
+		fb.HTTPConfig(&cfg.HTTPConfig, "config file defining the http client configuration used to connect to the remote host")
+		fb.RateLimit(&cfg.RateLimit)
+		fb.SourceProtocol(&cfg.SourceProtocol, "protocol used to read data from the remote host (remote_read or query_range)")
+		fb.SourceDirectory(&cfg.SourceDirectory, "local TSDB directory or snapshot to migrate data from instead of the remote host")
 		fb.Parallelism(&cfg.Parallelism, 4, "parallelism for migration")
+		fb.BlockWriter(&cfg.BlockWriter)
 	})
 }
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/repairer"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"repair",
+		"Repair prometheus TSDB",
+		"Repair the blocks of a local prometheus TSDB which fail verification by rewriting or dropping broken blocks, fixing their meta.json, and compacting overlapping blocks.",
+		new(config.RepairConfig),
+		repairer.NewRepairer()).Configure(func(fb config.FlagBuilder, cfg *config.RepairConfig) {
+		fb.Directory(&cfg.Directory, "directory read and write TSDB data")
+		fb.DryRun(&cfg.DryRun, "report the changes which would be made without making them")
+	})
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/rewriter"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"rewrite",
+		"Rewrite prometheus data",
+		"Rewrite the metric names and labels of the blocks in a local prometheus TSDB, replacing the blocks with rewritten blocks with the same time ranges.",
+		new(config.RewriteConfig),
+		rewriter.NewRewriter()).Configure(func(fb config.FlagBuilder, cfg *config.RewriteConfig) {
+		fb.Directory(&cfg.Directory, "directory read and write TSDB data")
+		fb.RewriteMapping(&cfg.Mapping, "config file defining the metric renames, label renames and relabel configs to apply").Required()
+		fb.BackupDirectory(&cfg.BackupDirectory, "directory to move the original blocks to, which must be on the same filesystem, instead of deleting them")
+		fb.DryRun(&cfg.DryRun, "report the changes which would be made without making them")
+	})
+}
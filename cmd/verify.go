@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/verifier"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"verify",
+		"Verify prometheus TSDB",
+		"Verify the meta.json, index, chunks and time range of every block in a local prometheus TSDB, and that blocks don't overlap.",
+		new(config.VerifyConfig),
+		verifier.NewVerifier()).Configure(func(fb config.FlagBuilder, cfg *config.VerifyConfig) {
+		fb.Directory(&cfg.Directory, "directory to read TSDB data")
+	})
+}
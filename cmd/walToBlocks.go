@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/walconverter"
+)
+
+func init() {
+	command.NewCommand(
+		Root,
+		"wal-to-blocks",
+		"Convert prometheus WAL to blocks",
+		"Replay the WAL and checkpoint of a copy of a prometheus data directory, and write the samples which aren't in its blocks yet to aligned blocks in a local prometheus TSDB.",
+		new(config.WalToBlocksConfig),
+		walconverter.NewWalConverter()).Configure(func(fb config.FlagBuilder, cfg *config.WalToBlocksConfig) {
+		fb.SourceDirectory(&cfg.SourceDirectory, "copy of the prometheus data directory whose WAL is converted")
+		fb.OutputDirectory(&cfg.OutputDirectory, "directory write TSDB data")
+		fb.RemoteWriteConfig(&cfg.RemoteWriteConfig, "config file defining the remote write endpoint to send samples to instead of writing TSDB blocks")
+		fb.Parallelism(&cfg.Parallelism, 4, "parallelism for conversion")
+		fb.BlockWriter(&cfg.BlockWriter)
+	})
+}
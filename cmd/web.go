@@ -18,6 +18,8 @@ func init() {
 		fb.ListenAddress(&cfg.ListenAddress, "the listen address")
 		fb.SampleInterval(&cfg.SampleInterval, "interval at which samples will be taken within a range")
 		fb.Host(&cfg.Host, "remote prometheus host")
+		fb.HTTPConfig(&cfg.HTTPConfig, "config file defining the http client configuration used to connect to the remote host")
+		fb.RateLimit(&cfg.RateLimit)
 		fb.Parallelism(&cfg.Parallelism, block.MaxParallelism, "parallelism for backfill")
 	})
 }
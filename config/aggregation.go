@@ -0,0 +1,73 @@
+package config
+
+import (
+	"bytes"
+	"github.com/kadaan/promutil/lib/errors"
+	"strings"
+)
+
+type Aggregation string
+
+const (
+	MinAggregation   Aggregation = "min"
+	MaxAggregation   Aggregation = "max"
+	SumAggregation   Aggregation = "sum"
+	CountAggregation Aggregation = "count"
+	RateAggregation  Aggregation = "rate"
+)
+
+var (
+	aggregations = []Aggregation{MinAggregation, MaxAggregation, SumAggregation, CountAggregation, RateAggregation}
+)
+
+type aggregationArrayValue struct {
+	value   *[]Aggregation
+	changed bool
+}
+
+func NewAggregationsValue(p *[]Aggregation, val []Aggregation) *aggregationArrayValue {
+	aav := new(aggregationArrayValue)
+	aav.value = p
+	*aav.value = val
+	return aav
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *aggregationArrayValue) String() string {
+	b := &bytes.Buffer{}
+	for _, a := range *e.value {
+		b.WriteString(string(a))
+		b.WriteString(",")
+	}
+	return strings.TrimSuffix(b.String(), ",")
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *aggregationArrayValue) Set(v string) error {
+	if !e.changed {
+		*e.value = nil
+		e.changed = true
+	}
+	for _, s := range strings.Split(v, ",") {
+		aggregation, err := parseAggregation(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		*e.value = append(*e.value, aggregation)
+	}
+	return nil
+}
+
+// Type is only used in help text
+func (e *aggregationArrayValue) Type() string {
+	return "aggregations"
+}
+
+func parseAggregation(v string) (Aggregation, error) {
+	for _, a := range aggregations {
+		if strings.EqualFold(string(a), v) {
+			return a, nil
+		}
+	}
+	return "", errors.New("aggregation must be one of %s", aggregations)
+}
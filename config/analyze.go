@@ -0,0 +1,13 @@
+package config
+
+import (
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// AnalyzeConfig represents the configuration of the analyze command.
+type AnalyzeConfig struct {
+	Directory string
+	Matchers  map[string][]*labels.Matcher
+	Format    ReportFormat
+	Limit     uint
+}
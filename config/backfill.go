@@ -1,18 +1,21 @@
 package config
 
 import (
+	prometheusConfig "github.com/prometheus/prometheus/config"
 	"regexp"
 	"time"
 )
 
 // BackfillConfig represents the configuration of the backfill command.
 type BackfillConfig struct {
-	Start            time.Time
-	End              time.Time
-	SampleInterval   time.Duration
-	RuleConfig       RecordingRules
-	RuleGroupFilters []*regexp.Regexp
-	RuleNameFilters  []*regexp.Regexp
-	Directory        string
-	Parallelism      uint8
+	Start             time.Time
+	End               time.Time
+	SampleInterval    time.Duration
+	RuleConfig        RecordingRules
+	RuleGroupFilters  []*regexp.Regexp
+	RuleNameFilters   []*regexp.Regexp
+	RemoteWriteConfig *prometheusConfig.RemoteWriteConfig
+	Directory         string
+	Parallelism       uint8
+	BlockWriter       BlockWriterConfig
 }
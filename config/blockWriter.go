@@ -0,0 +1,23 @@
+package config
+
+import (
+	"time"
+)
+
+// BlockWriterConfig represents the configuration shared by the commands which write samples to blocks or remote write
+// endpoints.
+type BlockWriterConfig struct {
+	Strict             bool
+	MaxMemory          int64
+	ExternalLabels     map[string]string
+	BucketDirectory    string
+	BlockDuration      time.Duration
+	ChunkStrategy      string
+	ChunkDuration      time.Duration
+	TargetSamples      uint
+	TargetEntryRuntime time.Duration
+	Retries            uint
+	RetryBackoff       time.Duration
+	ContinueOnError    bool
+	FailedEntriesFile  string
+}
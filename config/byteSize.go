@@ -0,0 +1,40 @@
+package config
+
+import (
+	"github.com/dustin/go-humanize"
+	"github.com/kadaan/promutil/lib/errors"
+	"math"
+)
+
+type byteSizeValue int64
+
+func NewByteSizeValue(p *int64, val int64) *byteSizeValue {
+	*p = val
+	return (*byteSizeValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *byteSizeValue) String() string {
+	if *e <= 0 {
+		return "unlimited"
+	}
+	return humanize.IBytes(uint64(*e))
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *byteSizeValue) Set(v string) error {
+	size, err := humanize.ParseBytes(v)
+	if err != nil {
+		return errors.Wrap(err, "could not parse byte size %s", v)
+	}
+	if size > math.MaxInt64 {
+		return errors.New("byte size %s is too large", v)
+	}
+	*e = byteSizeValue(size)
+	return nil
+}
+
+// Type is only used in help text
+func (e *byteSizeValue) Type() string {
+	return "byteSize"
+}
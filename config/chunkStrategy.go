@@ -0,0 +1,47 @@
+package config
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	"strings"
+)
+
+type ChunkStrategy string
+
+const (
+	CountChunkStrategy    ChunkStrategy = "count"
+	DurationChunkStrategy ChunkStrategy = "duration"
+	SamplesChunkStrategy  ChunkStrategy = "samples"
+	AdaptiveChunkStrategy ChunkStrategy = "adaptive"
+)
+
+var (
+	chunkStrategies = []ChunkStrategy{CountChunkStrategy, DurationChunkStrategy, SamplesChunkStrategy, AdaptiveChunkStrategy}
+)
+
+type chunkStrategyValue ChunkStrategy
+
+func NewChunkStrategyValue(p *string, val ChunkStrategy) *chunkStrategyValue {
+	*p = string(val)
+	return (*chunkStrategyValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *chunkStrategyValue) String() string {
+	return string(*e)
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *chunkStrategyValue) Set(v string) error {
+	for _, f := range chunkStrategies {
+		if strings.EqualFold(string(f), v) {
+			*e = chunkStrategyValue(f)
+			return nil
+		}
+	}
+	return errors.New("chunk strategy must be one of %s", chunkStrategies)
+}
+
+// Type is only used in help text
+func (e *chunkStrategyValue) Type() string {
+	return "chunkStrategy"
+}
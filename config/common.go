@@ -2,6 +2,9 @@ package config
 
 import (
 	"github.com/kadaan/promutil/lib/errors"
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	prometheusConfig "github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -14,6 +17,7 @@ import (
 const (
 	directoryKey          = "directory"
 	outputDirectoryKey    = "output-directory"
+	sourceDirectoryKey    = "source-directory"
 	startKey              = "start"
 	endKey                = "end"
 	sampleIntervalKey     = "sample-interval"
@@ -22,11 +26,55 @@ const (
 	ruleGroupFilterKey    = "rule-group-filter"
 	ruleNameFilterKey     = "rule-name-filter"
 	metricConfigFileKey   = "metric-config-file"
+	relabelConfigFileKey  = "relabel-config-file"
 	hostKey               = "host"
+	httpConfigFileKey     = "http-config-file"
+	remoteWriteConfigKey  = "remote-write-config-file"
 	matcherKey            = "matcher"
+	sourceProtocolKey     = "source-protocol"
+	maxSeriesKey          = "max-series-per-request"
+	maxRequestsRateKey    = "max-requests-per-second"
+	maxSamplesRateKey     = "max-samples-per-second"
+	slowdownLatencyKey    = "slowdown-latency"
+	dedupLabelKey         = "dedup-label"
+	minBlockDurationKey   = "min-block-duration"
+	maxBlockDurationKey   = "max-block-duration"
+	maxBlockSizeKey       = "max-block-size"
+	dryRunKey             = "dry-run"
+	cleanTombstonesKey    = "clean-tombstones"
+	formatKey             = "format"
+	limitKey              = "limit"
+	mappingFileKey        = "mapping-file"
+	stepKey               = "step"
+	outputFileKey         = "output-file"
+	inputFileKey          = "input-file"
+	csvMappingFileKey     = "csv-mapping-file"
+	errorReportFileKey    = "error-report-file"
+	strictKey             = "strict"
+	maxMemoryKey          = "max-memory"
+	resolutionKey         = "resolution"
+	aggregationKey        = "aggregation"
+	targetDirectoryKey    = "target-directory"
+	targetHostKey         = "target-host"
+	toleranceKey          = "tolerance"
+	externalLabelKey      = "external-label"
+	bucketDirectoryKey    = "bucket-dir"
+	blockDurationKey      = "block-duration"
+	chunkStrategyKey      = "chunk-strategy"
+	chunkDurationKey      = "chunk-duration"
+	targetSamplesKey      = "target-samples"
+	targetEntryRuntimeKey = "target-entry-runtime"
+	retriesKey            = "retries"
+	retryBackoffKey       = "retry-backoff"
+	continueOnErrorKey    = "continue-on-error"
+	failedEntriesFileKey  = "failed-entries-file"
+	backupDirectoryKey    = "backup-directory"
 	listenAddressKey      = "listenAddress"
 	defaultSampleInterval = time.Second * 15
+	defaultLimit          = 10
 	defaultDataDirectory  = "data/"
+	defaultBlockDuration  = 2 * time.Hour
+	maxBlockDuration      = 31 * 24 * time.Hour
 )
 
 var (
@@ -35,6 +83,7 @@ var (
 	defaultHost             = MustParseUrl("http://localhost:9090")
 	defaultRuleGroupFilters = []*regexp.Regexp{regexp.MustCompile(".+")}
 	defaultRuleNameFilters  = []*regexp.Regexp{regexp.MustCompile(".+")}
+	defaultAggregations     = []Aggregation{MinAggregation, MaxAggregation, SumAggregation, CountAggregation, RateAggregation}
 	yamlFileExtensions      = []string{"yml", "yaml"}
 	defaultListenAddress    = ListenAddress{Host: "", Port: 8080}
 )
@@ -87,18 +136,50 @@ type FlagBuilder interface {
 	Time(dest *time.Time, name string, defaultValue time.Time, usage string) Flag
 	OutputDirectory(dest *string, usage string) Flag
 	Directory(dest *string, usage string) Flag
+	SourceDirectory(dest *string, usage string) Flag
+	BackupDirectory(dest *string, usage string) Flag
+	TargetDirectory(dest *string, usage string) Flag
 	MetricConfig(dest *MetricConfig, usage string) FileFlag
 	File(dest *string, name string, defaultValue string, usage string) FileFlag
 	SampleInterval(dest *time.Duration, usage string) Flag
 	Duration(dest *time.Duration, name string, defaultValue time.Duration, usage string) Flag
 	RecordingRules(dest *RecordingRules, usage string) Flag
+	RelabelConfig(dest *RelabelConfig, usage string) FileFlag
+	RewriteMapping(dest *RewriteMapping, usage string) FileFlag
 	Parallelism(dest *uint8, defaultValue uint8, usage string) Flag
+	Uint(dest *uint, name string, defaultValue uint, usage string) Flag
+	MaxSeriesPerRequest(dest *uint, usage string) Flag
+	Float64(dest *float64, name string, defaultValue float64, usage string) Flag
+	RateLimit(dest *RateLimitConfig) Flag
+	BlockWriter(dest *BlockWriterConfig) Flag
 	Regex(dest *[]*regexp.Regexp, name string, defaultValue []*regexp.Regexp, usage string) Flag
 	RuleGroupFilters(dest *[]*regexp.Regexp, usage string) Flag
 	RuleNameFilters(dest *[]*regexp.Regexp, usage string) Flag
 	URL(dest **url.URL, name string, defaultValue *url.URL, usage string) Flag
 	Host(dest **url.URL, usage string) Flag
+	TargetHost(dest **url.URL, usage string) Flag
+	HTTPConfig(dest *promConfig.HTTPClientConfig, usage string) FileFlag
+	RemoteWriteConfig(dest **prometheusConfig.RemoteWriteConfig, usage string) FileFlag
 	Matchers(dest *map[string][]*labels.Matcher, usage string) Flag
+	DedupLabels(dest *[]string, usage string) Flag
+	ByteSize(dest *int64, name string, defaultValue int64, usage string) Flag
+	Bool(dest *bool, name string, defaultValue bool, usage string) Flag
+	DryRun(dest *bool, usage string) Flag
+	CleanTombstones(dest *bool, usage string) Flag
+	BlockRanges(minDest *time.Duration, maxDest *time.Duration, sizeDest *int64) Flag
+	SourceProtocol(dest *SourceProtocol, usage string) Flag
+	ReportFormat(dest *ReportFormat, usage string) Flag
+	ExportFormat(dest *ExportFormat, usage string) Flag
+	Step(dest *time.Duration, usage string) Flag
+	OutputFile(dest *string, usage string) FileFlag
+	InputFiles(dest *[]string, usage string) Flag
+	ImportFormat(dest *ImportFormat, usage string) Flag
+	CSVMapping(dest *CSVMapping, usage string) FileFlag
+	ErrorReportFile(dest *string, usage string) FileFlag
+	Resolution(dest *time.Duration, usage string) Flag
+	Aggregations(dest *[]Aggregation, usage string) Flag
+	Tolerance(dest *float64, usage string) Flag
+	Limit(dest *uint, usage string) Flag
 	ListenAddress(dest *ListenAddress, usage string) Flag
 }
 
@@ -166,6 +247,18 @@ func (fb *flagBuilder) Directory(dest *string, usage string) Flag {
 	return fb.directory(dest, directoryKey, defaultDataDirectory, usage)
 }
 
+func (fb *flagBuilder) SourceDirectory(dest *string, usage string) Flag {
+	return fb.directory(dest, sourceDirectoryKey, "", usage)
+}
+
+func (fb *flagBuilder) BackupDirectory(dest *string, usage string) Flag {
+	return fb.directory(dest, backupDirectoryKey, "", usage)
+}
+
+func (fb *flagBuilder) TargetDirectory(dest *string, usage string) Flag {
+	return fb.directory(dest, targetDirectoryKey, "", usage)
+}
+
 func (fb *flagBuilder) directory(dest *string, name string, defaultValue string, usage string) Flag {
 	return fb.newFlag(name, func(flagSet *pflag.FlagSet) {
 		flagSet.StringVar(dest, name, defaultValue, usage)
@@ -204,12 +297,103 @@ func (fb *flagBuilder) RecordingRules(dest *RecordingRules, usage string) Flag {
 	})
 }
 
+func (fb *flagBuilder) RelabelConfig(dest *RelabelConfig, usage string) FileFlag {
+	return fb.newFlag(relabelConfigFileKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewRelabelConfigValue(dest), relabelConfigFileKey, usage)
+		_ = fb.cmd.MarkFlagFilename(relabelConfigFileKey, yamlFileExtensions...)
+	})
+}
+
+func (fb *flagBuilder) RewriteMapping(dest *RewriteMapping, usage string) FileFlag {
+	return fb.newFlag(mappingFileKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewRewriteMappingValue(dest), mappingFileKey, usage)
+		_ = fb.cmd.MarkFlagFilename(mappingFileKey, yamlFileExtensions...)
+	})
+}
+
 func (fb *flagBuilder) Parallelism(dest *uint8, defaultValue uint8, usage string) Flag {
 	return fb.newFlag(parallelismKey, func(flagSet *pflag.FlagSet) {
 		flagSet.Uint8Var(dest, parallelismKey, defaultValue, usage)
 	})
 }
 
+func (fb *flagBuilder) Uint(dest *uint, name string, defaultValue uint, usage string) Flag {
+	return fb.newFlag(name, func(flagSet *pflag.FlagSet) {
+		flagSet.UintVar(dest, name, defaultValue, usage)
+	})
+}
+
+func (fb *flagBuilder) MaxSeriesPerRequest(dest *uint, usage string) Flag {
+	return fb.Uint(dest, maxSeriesKey, 0, usage)
+}
+
+func (fb *flagBuilder) Float64(dest *float64, name string, defaultValue float64, usage string) Flag {
+	return fb.newFlag(name, func(flagSet *pflag.FlagSet) {
+		flagSet.Float64Var(dest, name, defaultValue, usage)
+	})
+}
+
+func (fb *flagBuilder) RateLimit(dest *RateLimitConfig) Flag {
+	requestsFlag := fb.Float64(&dest.RequestsPerSecond, maxRequestsRateKey, 0, "maximum number of requests per second sent to the remote host, unlimited when zero")
+	samplesFlag := fb.Float64(&dest.SamplesPerSecond, maxSamplesRateKey, 0, "maximum number of samples per second read from the remote host, unlimited when zero")
+	latencyFlag := fb.Duration(&dest.SlowdownLatency, slowdownLatencyKey, 0, "response latency above which requests to the remote host are slowed down, disabled when zero")
+	fb.addValidation(func(cmd *cobra.Command, args []string) error {
+		if dest.RequestsPerSecond < 0 {
+			return errors.New("%s must not be negative", maxRequestsRateKey)
+		}
+		if dest.SamplesPerSecond < 0 {
+			return errors.New("%s must not be negative", maxSamplesRateKey)
+		}
+		return nil
+	})
+	return &compositeFlag{
+		flags: []Flag{requestsFlag, samplesFlag, latencyFlag},
+	}
+}
+
+func (fb *flagBuilder) BlockWriter(dest *BlockWriterConfig) Flag {
+	strictFlag := fb.Bool(&dest.Strict, strictKey, false, "fail when any samples are dropped because they are out of order, out of bounds or duplicated")
+	maxMemoryFlag := fb.ByteSize(&dest.MaxMemory, maxMemoryKey, 0, "maximum estimated memory used by the appenders writing blocks, unlimited when zero")
+	externalLabelsFlag := fb.newFlag(externalLabelKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewExternalLabelsValue(&dest.ExternalLabels), externalLabelKey, "external label added to the thanos section of the meta.json of the written blocks, formatted as name=value")
+	})
+	bucketDirectoryFlag := fb.directory(&dest.BucketDirectory, bucketDirectoryKey, "", "filesystem object storage bucket to write thanos blocks to instead of the output directory")
+	blockDurationFlag := fb.Duration(&dest.BlockDuration, blockDurationKey, 0, "duration of the blocks which are planned one at a time and compacted in the output, chosen from the time range when zero")
+	chunkStrategyFlag := fb.newFlag(chunkStrategyKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewChunkStrategyValue(&dest.ChunkStrategy, CountChunkStrategy), chunkStrategyKey, "strategy splitting blocks into the chunks planned for each entry (count, duration, samples or adaptive)")
+	})
+	chunkDurationFlag := fb.Duration(&dest.ChunkDuration, chunkDurationKey, 30*time.Minute, "duration of the chunks with the duration chunk strategy")
+	targetSamplesFlag := fb.Uint(&dest.TargetSamples, targetSamplesKey, 100000, "samples per plan entry targeted by the samples chunk strategy")
+	targetEntryRuntimeFlag := fb.Duration(&dest.TargetEntryRuntime, targetEntryRuntimeKey, 10*time.Second, "runtime per plan entry targeted by the adaptive chunk strategy")
+	retriesFlag := fb.Uint(&dest.Retries, retriesKey, 0, "number of times a failed plan entry is retried")
+	retryBackoffFlag := fb.Duration(&dest.RetryBackoff, retryBackoffKey, time.Second, "backoff before the first retry of a failed plan entry, which grows exponentially with every retry")
+	continueOnErrorFlag := fb.Bool(&dest.ContinueOnError, continueOnErrorKey, false, "run the rest of the plan when plan entries fail, and fail once the plan is complete")
+	failedEntriesFileFlag := fb.File(&dest.FailedEntriesFile, failedEntriesFileKey, "", "file to write the failed plan entries to as CSV")
+	fb.addValidation(func(cmd *cobra.Command, args []string) error {
+		if dest.BlockDuration != 0 && (dest.BlockDuration < time.Minute || dest.BlockDuration > maxBlockDuration) {
+			return errors.New("%s must be between 1m and %s when set", blockDurationKey, maxBlockDuration)
+		}
+		if dest.ChunkDuration < time.Millisecond {
+			return errors.New("%s must be at least 1ms", chunkDurationKey)
+		}
+		if dest.TargetSamples == 0 {
+			return errors.New("%s must be positive", targetSamplesKey)
+		}
+		if dest.TargetEntryRuntime < time.Millisecond {
+			return errors.New("%s must be at least 1ms", targetEntryRuntimeKey)
+		}
+		if dest.RetryBackoff < time.Millisecond {
+			return errors.New("%s must be at least 1ms", retryBackoffKey)
+		}
+		return nil
+	})
+	return &compositeFlag{
+		flags: []Flag{strictFlag, maxMemoryFlag, externalLabelsFlag, bucketDirectoryFlag, blockDurationFlag,
+			chunkStrategyFlag, chunkDurationFlag, targetSamplesFlag, targetEntryRuntimeFlag, retriesFlag, retryBackoffFlag,
+			continueOnErrorFlag, failedEntriesFileFlag},
+	}
+}
+
 func (fb *flagBuilder) Regex(dest *[]*regexp.Regexp, name string, defaultValue []*regexp.Regexp, usage string) Flag {
 	return fb.newFlag(name, func(flagSet *pflag.FlagSet) {
 		flagSet.Var(NewRegexValue(dest, defaultValue), name, usage)
@@ -234,12 +418,162 @@ func (fb *flagBuilder) Host(dest **url.URL, usage string) Flag {
 	return fb.URL(dest, hostKey, defaultHost, usage)
 }
 
+func (fb *flagBuilder) TargetHost(dest **url.URL, usage string) Flag {
+	return fb.URL(dest, targetHostKey, defaultHost, usage)
+}
+
+func (fb *flagBuilder) HTTPConfig(dest *promConfig.HTTPClientConfig, usage string) FileFlag {
+	return fb.newFlag(httpConfigFileKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewHTTPConfigValue(dest), httpConfigFileKey, usage)
+		_ = fb.cmd.MarkFlagFilename(httpConfigFileKey, yamlFileExtensions...)
+	})
+}
+
+func (fb *flagBuilder) RemoteWriteConfig(dest **prometheusConfig.RemoteWriteConfig, usage string) FileFlag {
+	return fb.newFlag(remoteWriteConfigKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewRemoteWriteConfigValue(dest), remoteWriteConfigKey, usage)
+		_ = fb.cmd.MarkFlagFilename(remoteWriteConfigKey, yamlFileExtensions...)
+	})
+}
+
 func (fb *flagBuilder) Matchers(dest *map[string][]*labels.Matcher, usage string) Flag {
 	return fb.newFlag(matcherKey, func(flagSet *pflag.FlagSet) {
 		flagSet.Var(NewMatchersValue(dest), matcherKey, usage)
 	})
 }
 
+func (fb *flagBuilder) DedupLabels(dest *[]string, usage string) Flag {
+	fb.addValidation(func(cmd *cobra.Command, args []string) error {
+		for _, name := range *dest {
+			if !model.LabelName(name).IsValidLegacy() {
+				return errors.New("invalid %s: %s", dedupLabelKey, name)
+			}
+		}
+		return nil
+	})
+	return fb.newFlag(dedupLabelKey, func(flagSet *pflag.FlagSet) {
+		flagSet.StringArrayVar(dest, dedupLabelKey, nil, usage)
+	})
+}
+
+func (fb *flagBuilder) Bool(dest *bool, name string, defaultValue bool, usage string) Flag {
+	return fb.newFlag(name, func(flagSet *pflag.FlagSet) {
+		flagSet.BoolVar(dest, name, defaultValue, usage)
+	})
+}
+
+func (fb *flagBuilder) DryRun(dest *bool, usage string) Flag {
+	return fb.Bool(dest, dryRunKey, false, usage)
+}
+
+func (fb *flagBuilder) CleanTombstones(dest *bool, usage string) Flag {
+	return fb.Bool(dest, cleanTombstonesKey, false, usage)
+}
+
+func (fb *flagBuilder) ByteSize(dest *int64, name string, defaultValue int64, usage string) Flag {
+	return fb.newFlag(name, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewByteSizeValue(dest, defaultValue), name, usage)
+	})
+}
+
+func (fb *flagBuilder) BlockRanges(minDest *time.Duration, maxDest *time.Duration, sizeDest *int64) Flag {
+	minFlag := fb.Duration(minDest, minBlockDurationKey, defaultBlockDuration, "minimum duration of the blocks, compaction levels are exponential multiples of it")
+	maxFlag := fb.Duration(maxDest, maxBlockDurationKey, maxBlockDuration, "maximum duration of the compacted blocks")
+	sizeFlag := fb.ByteSize(sizeDest, maxBlockSizeKey, 0, "maximum size of the compacted blocks, unlimited when zero")
+	fb.addValidation(func(cmd *cobra.Command, args []string) error {
+		if *minDest < time.Millisecond {
+			return errors.New("%s must be at least 1ms", minBlockDurationKey)
+		}
+		if *maxDest < *minDest {
+			return errors.New("%s must not be less than %s", maxBlockDurationKey, minBlockDurationKey)
+		}
+		return nil
+	})
+	return &compositeFlag{
+		flags: []Flag{minFlag, maxFlag, sizeFlag},
+	}
+}
+
+func (fb *flagBuilder) SourceProtocol(dest *SourceProtocol, usage string) Flag {
+	return fb.newFlag(sourceProtocolKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewSourceProtocolValue(dest, RemoteReadSourceProtocol), sourceProtocolKey, usage)
+	})
+}
+
+func (fb *flagBuilder) ReportFormat(dest *ReportFormat, usage string) Flag {
+	return fb.newFlag(formatKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewReportFormatValue(dest, TextReportFormat), formatKey, usage)
+	})
+}
+
+func (fb *flagBuilder) ExportFormat(dest *ExportFormat, usage string) Flag {
+	return fb.newFlag(formatKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewExportFormatValue(dest, OpenMetricsExportFormat), formatKey, usage)
+	})
+}
+
+func (fb *flagBuilder) Step(dest *time.Duration, usage string) Flag {
+	return fb.Duration(dest, stepKey, 0, usage)
+}
+
+func (fb *flagBuilder) OutputFile(dest *string, usage string) FileFlag {
+	return fb.File(dest, outputFileKey, "", usage)
+}
+
+func (fb *flagBuilder) InputFiles(dest *[]string, usage string) Flag {
+	return fb.newFlag(inputFileKey, func(flagSet *pflag.FlagSet) {
+		flagSet.StringArrayVar(dest, inputFileKey, nil, usage)
+		_ = fb.cmd.MarkFlagFilename(inputFileKey)
+	})
+}
+
+func (fb *flagBuilder) ImportFormat(dest *ImportFormat, usage string) Flag {
+	return fb.newFlag(formatKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewImportFormatValue(dest, OpenMetricsImportFormat), formatKey, usage)
+	})
+}
+
+func (fb *flagBuilder) CSVMapping(dest *CSVMapping, usage string) FileFlag {
+	return fb.newFlag(csvMappingFileKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewCSVMappingValue(dest), csvMappingFileKey, usage)
+		_ = fb.cmd.MarkFlagFilename(csvMappingFileKey, yamlFileExtensions...)
+	})
+}
+
+func (fb *flagBuilder) ErrorReportFile(dest *string, usage string) FileFlag {
+	return fb.File(dest, errorReportFileKey, "", usage)
+}
+
+func (fb *flagBuilder) Resolution(dest *time.Duration, usage string) Flag {
+	fb.addValidation(func(cmd *cobra.Command, args []string) error {
+		if *dest < time.Second {
+			return errors.New("%s must be at least 1s", resolutionKey)
+		}
+		return nil
+	})
+	return fb.Duration(dest, resolutionKey, 5*time.Minute, usage)
+}
+
+func (fb *flagBuilder) Aggregations(dest *[]Aggregation, usage string) Flag {
+	return fb.newFlag(aggregationKey, func(flagSet *pflag.FlagSet) {
+		flagSet.Var(NewAggregationsValue(dest, defaultAggregations), aggregationKey, usage)
+	})
+}
+
+func (fb *flagBuilder) Tolerance(dest *float64, usage string) Flag {
+	fb.addValidation(func(cmd *cobra.Command, args []string) error {
+		if *dest < 0 {
+			return errors.New("%s must not be negative", toleranceKey)
+		}
+		return nil
+	})
+	return fb.Float64(dest, toleranceKey, 0, usage)
+}
+
+func (fb *flagBuilder) Limit(dest *uint, usage string) Flag {
+	return fb.Uint(dest, limitKey, defaultLimit, usage)
+}
+
 func (fb *flagBuilder) ListenAddress(dest *ListenAddress, usage string) Flag {
 	return fb.newFlag(listenAddressKey, func(flagSet *pflag.FlagSet) {
 		flagSet.Var(NewListenAddressValue(dest, defaultListenAddress), listenAddressKey, usage)
@@ -1,6 +1,11 @@
 package config
 
+import "time"
+
 // CompactConfig represents the configuration of the compact command.
 type CompactConfig struct {
-	Directory string
+	Directory        string
+	MinBlockDuration time.Duration
+	MaxBlockDuration time.Duration
+	MaxBlockSize     int64
 }
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	"os"
+	"unicode/utf8"
+)
+
+const (
+	defaultTimestampColumn = "timestamp"
+	defaultSeriesColumn    = "series"
+	defaultValueColumn     = "value"
+	defaultDelimiter       = ","
+)
+
+// CSVMapping describes how the columns of CSV input map onto series and samples.  Rows either hold a single sample,
+// with the series taken from the series or metric column, or a sample for each of the value columns.
+type CSVMapping struct {
+	TimestampColumn string            `yaml:"timestamp_column,omitempty"`
+	TimestampFormat string            `yaml:"timestamp_format,omitempty"`
+	SeriesColumn    string            `yaml:"series_column,omitempty"`
+	MetricColumn    string            `yaml:"metric_column,omitempty"`
+	ValueColumn     string            `yaml:"value_column,omitempty"`
+	ValueColumns    map[string]string `yaml:"value_columns,omitempty"`
+	LabelColumns    map[string]string `yaml:"label_columns,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty"`
+	Delimiter       string            `yaml:"delimiter,omitempty"`
+}
+
+func defaultCSVMapping() CSVMapping {
+	return CSVMapping{
+		TimestampColumn: defaultTimestampColumn,
+		SeriesColumn:    defaultSeriesColumn,
+		ValueColumn:     defaultValueColumn,
+		Delimiter:       defaultDelimiter,
+	}
+}
+
+type csvMappingValue CSVMapping
+
+func NewCSVMappingValue(p *CSVMapping) *csvMappingValue {
+	*p = defaultCSVMapping()
+	return (*csvMappingValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *csvMappingValue) String() string {
+	if len(e.ValueColumns) == 0 && len(e.LabelColumns) == 0 && e.MetricColumn == "" {
+		return "None"
+	}
+	return fmt.Sprintf("%d value columns, %d label columns", len(e.ValueColumns), len(e.LabelColumns))
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *csvMappingValue) Set(v string) error {
+	mapping := defaultCSVMapping()
+	if _, err := os.Stat(v); err != nil {
+		return errors.Wrap(err, "could not find file %s", v)
+	}
+	yamlFile, err := os.ReadFile(v)
+	if err != nil {
+		return errors.Wrap(err, "could not read file %s", v)
+	}
+	err = yaml.UnmarshalStrict(yamlFile, &mapping)
+	if err != nil {
+		return errors.Wrap(err, "could not parse file %s", v)
+	}
+	if utf8.RuneCountInString(mapping.Delimiter) != 1 {
+		return errors.New("delimiter must be a single character in file %s", v)
+	}
+	for column, name := range mapping.LabelColumns {
+		if !model.LabelName(name).IsValidLegacy() {
+			return errors.New("invalid label name '%s' for column '%s' in file %s", name, column, v)
+		}
+	}
+	for name := range mapping.Labels {
+		if !model.LabelName(name).IsValidLegacy() || name == model.MetricNameLabel {
+			return errors.New("invalid label name '%s' in file %s", name, v)
+		}
+	}
+	*e = csvMappingValue(mapping)
+	return nil
+}
+
+// Type is only used in help text
+func (e *csvMappingValue) Type() string {
+	return "csvMapping"
+}
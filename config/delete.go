@@ -0,0 +1,16 @@
+package config
+
+import (
+	"github.com/prometheus/prometheus/model/labels"
+	"time"
+)
+
+// DeleteConfig represents the configuration of the delete command.
+type DeleteConfig struct {
+	Directory       string
+	Start           time.Time
+	End             time.Time
+	Matchers        map[string][]*labels.Matcher
+	CleanTombstones bool
+	DryRun          bool
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"net/url"
+	"time"
+)
+
+// DiffConfig represents the configuration of the diff command.
+type DiffConfig struct {
+	Host            *url.URL
+	TargetHost      *url.URL
+	HTTPConfig      promConfig.HTTPClientConfig
+	RateLimit       RateLimitConfig
+	SourceDirectory string
+	TargetDirectory string
+	Start           time.Time
+	End             time.Time
+	Matchers        map[string][]*labels.Matcher
+	Tolerance       float64
+	OutputFile      string
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	prometheusConfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"time"
+)
+
+// DownsampleConfig represents the configuration of the downsample command.
+type DownsampleConfig struct {
+	SourceDirectory   string
+	Start             time.Time
+	End               time.Time
+	Matchers          map[string][]*labels.Matcher
+	Resolution        time.Duration
+	Aggregations      []Aggregation
+	RemoteWriteConfig *prometheusConfig.RemoteWriteConfig
+	OutputDirectory   string
+	Parallelism       uint8
+	BlockWriter       BlockWriterConfig
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"net/url"
+	"time"
+)
+
+// ExportConfig represents the configuration of the export command.
+type ExportConfig struct {
+	Host            *url.URL
+	HTTPConfig      promConfig.HTTPClientConfig
+	RateLimit       RateLimitConfig
+	SourceDirectory string
+	Start           time.Time
+	End             time.Time
+	Matchers        map[string][]*labels.Matcher
+	Format          ExportFormat
+	Step            time.Duration
+	OutputFile      string
+}
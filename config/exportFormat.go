@@ -0,0 +1,47 @@
+package config
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	"strings"
+)
+
+type ExportFormat string
+
+const (
+	OpenMetricsExportFormat ExportFormat = "openmetrics"
+	CSVExportFormat         ExportFormat = "csv"
+	WideCSVExportFormat     ExportFormat = "csv-wide"
+	JSONLinesExportFormat   ExportFormat = "jsonl"
+)
+
+var (
+	exportFormats = []ExportFormat{OpenMetricsExportFormat, CSVExportFormat, WideCSVExportFormat, JSONLinesExportFormat}
+)
+
+type exportFormatValue ExportFormat
+
+func NewExportFormatValue(p *ExportFormat, val ExportFormat) *exportFormatValue {
+	*p = val
+	return (*exportFormatValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *exportFormatValue) String() string {
+	return string(*e)
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *exportFormatValue) Set(v string) error {
+	for _, f := range exportFormats {
+		if strings.EqualFold(string(f), v) {
+			*e = exportFormatValue(f)
+			return nil
+		}
+	}
+	return errors.New("export format must be one of %s", exportFormats)
+}
+
+// Type is only used in help text
+func (e *exportFormatValue) Type() string {
+	return "exportFormat"
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/common/model"
+	"strings"
+)
+
+type externalLabelsValue struct {
+	value *map[string]string
+}
+
+func NewExternalLabelsValue(p *map[string]string) *externalLabelsValue {
+	elv := new(externalLabelsValue)
+	elv.value = p
+	*elv.value = make(map[string]string)
+	return elv
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *externalLabelsValue) String() string {
+	size := len(*e.value)
+	if size == 0 {
+		return "None"
+	}
+	return fmt.Sprintf("%d label(s)", size)
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *externalLabelsValue) Set(v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return errors.New("external label must be formatted as name=value: %s", v)
+	}
+	if !model.LabelName(name).IsValidLegacy() {
+		return errors.New("invalid external label name: %s", name)
+	}
+	if value == "" {
+		return errors.New("external label %s has an empty value", name)
+	}
+	(*e.value)[name] = value
+	return nil
+}
+
+// Type is only used in help text
+func (e *externalLabelsValue) Type() string {
+	return "label"
+}
@@ -1,6 +1,9 @@
 package config
 
-import "time"
+import (
+	prometheusConfig "github.com/prometheus/prometheus/config"
+	"time"
+)
 
 const (
 	DefaultMetricConfigFile = ""
@@ -8,11 +11,13 @@ const (
 
 // GenerateConfig represents the configuration of the generate command.
 type GenerateConfig struct {
-	Start           time.Time
-	End             time.Time
-	OutputDirectory string
-	SampleInterval  time.Duration
-	MetricConfig    MetricConfig
-	RuleConfig      RecordingRules
-	Parallelism     uint8
+	Start             time.Time
+	End               time.Time
+	OutputDirectory   string
+	SampleInterval    time.Duration
+	MetricConfig      MetricConfig
+	RuleConfig        RecordingRules
+	RemoteWriteConfig *prometheusConfig.RemoteWriteConfig
+	Parallelism       uint8
+	BlockWriter       BlockWriterConfig
 }
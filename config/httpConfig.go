@@ -0,0 +1,58 @@
+package config
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	promConfig "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+)
+
+type httpConfigValue struct {
+	value *promConfig.HTTPClientConfig
+	file  string
+}
+
+func NewHTTPConfigValue(p *promConfig.HTTPClientConfig) *httpConfigValue {
+	hcv := new(httpConfigValue)
+	hcv.value = p
+	*hcv.value = promConfig.DefaultHTTPClientConfig
+	return hcv
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *httpConfigValue) String() string {
+	if e.file == "" {
+		return "None"
+	}
+	return e.file
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *httpConfigValue) Set(v string) error {
+	var httpConfig promConfig.HTTPClientConfig
+	if _, err := os.Stat(v); err != nil {
+		return errors.Wrap(err, "could not find file %s", v)
+	}
+	yamlFile, err := os.ReadFile(v)
+	if err != nil {
+		return errors.Wrap(err, "could not read file %s", v)
+	}
+	err = yaml.UnmarshalStrict(yamlFile, &httpConfig)
+	if err != nil {
+		return errors.Wrap(err, "could not parse file %s", v)
+	}
+	dir, err := filepath.Abs(filepath.Dir(v))
+	if err != nil {
+		return errors.Wrap(err, "could not determine directory of file %s", v)
+	}
+	httpConfig.SetDirectory(dir)
+	*e.value = httpConfig
+	e.file = v
+	return nil
+}
+
+// Type is only used in help text
+func (e *httpConfigValue) Type() string {
+	return "httpConfig"
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	promConfig "github.com/prometheus/common/config"
+	"github.com/spf13/pflag"
+)
+
+func TestHTTPConfigTLS(t *testing.T) {
+	dir := t.TempDir()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+	writePEM(t, filepath.Join(dir, "server-ca.pem"), "CERTIFICATE", server.Certificate().Raw)
+
+	clientCert, clientKey := newClientCertificate(t)
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", clientCert.Raw)
+	writePEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", clientKey)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	mtlsServer := httptest.NewUnstartedServer(handler)
+	mtlsServer.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		yaml    string
+		success bool
+	}{
+		{
+			name:    "unknown authority",
+			url:     server.URL,
+			yaml:    "tls_config: {}\n",
+			success: false,
+		},
+		{
+			name:    "ca file",
+			url:     server.URL,
+			yaml:    "tls_config:\n  ca_file: server-ca.pem\n",
+			success: true,
+		},
+		{
+			name:    "insecure skip verify",
+			url:     server.URL,
+			yaml:    "tls_config:\n  insecure_skip_verify: true\n",
+			success: true,
+		},
+		{
+			name:    "missing client certificate",
+			url:     mtlsServer.URL,
+			yaml:    "tls_config:\n  ca_file: server-ca.pem\n",
+			success: false,
+		},
+		{
+			name:    "client certificate",
+			url:     mtlsServer.URL,
+			yaml:    "tls_config:\n  ca_file: server-ca.pem\n  cert_file: client.pem\n  key_file: client-key.pem\n",
+			success: true,
+		},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(dir, fmt.Sprintf("http_config_%d.yml", i))
+			if err := os.WriteFile(file, []byte(test.yaml), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var httpConfig promConfig.HTTPClientConfig
+			flagSet := pflag.NewFlagSet(test.name, pflag.ContinueOnError)
+			flagSet.Var(NewHTTPConfigValue(&httpConfig), httpConfigFileKey, "")
+			if err := flagSet.Parse([]string{"--" + httpConfigFileKey, file}); err != nil {
+				t.Fatalf("failed to parse flag: %v", err)
+			}
+
+			client, err := promConfig.NewClientFromConfig(httpConfig, "test")
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			resp, err := client.Get(test.url)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if test.success && err != nil {
+				t.Fatalf("expected request to succeed: %v", err)
+			}
+			if !test.success && err == nil {
+				t.Fatal("expected request to fail")
+			}
+		})
+	}
+}
+
+func newClientCertificate(t *testing.T) (*x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "promutil"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, keyDer
+}
+
+func writePEM(t *testing.T, file string, blockType string, bytes []byte) {
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,17 @@
+package config
+
+import (
+	prometheusConfig "github.com/prometheus/prometheus/config"
+)
+
+// ImportConfig represents the configuration of the import command.
+type ImportConfig struct {
+	InputFiles        []string
+	Format            ImportFormat
+	CSVMapping        CSVMapping
+	ErrorReportFile   string
+	OutputDirectory   string
+	RemoteWriteConfig *prometheusConfig.RemoteWriteConfig
+	Parallelism       uint8
+	BlockWriter       BlockWriterConfig
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	"strings"
+)
+
+type ImportFormat string
+
+const (
+	OpenMetricsImportFormat ImportFormat = "openmetrics"
+	CSVImportFormat         ImportFormat = "csv"
+	JSONLinesImportFormat   ImportFormat = "jsonl"
+)
+
+var (
+	importFormats = []ImportFormat{OpenMetricsImportFormat, CSVImportFormat, JSONLinesImportFormat}
+)
+
+type importFormatValue ImportFormat
+
+func NewImportFormatValue(p *ImportFormat, val ImportFormat) *importFormatValue {
+	*p = val
+	return (*importFormatValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *importFormatValue) String() string {
+	return string(*e)
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *importFormatValue) Set(v string) error {
+	for _, f := range importFormats {
+		if strings.EqualFold(string(f), v) {
+			*e = importFormatValue(f)
+			return nil
+		}
+	}
+	return errors.New("import format must be one of %s", importFormats)
+}
+
+// Type is only used in help text
+func (e *importFormatValue) Type() string {
+	return "importFormat"
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	promConfig "github.com/prometheus/common/config"
+	prometheusConfig "github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/model/labels"
 	"net/url"
 	"time"
@@ -8,11 +10,20 @@ import (
 
 // MigrateConfig represents the configuration of the migrate command.
 type MigrateConfig struct {
-	Host            *url.URL
-	Start           time.Time
-	End             time.Time
-	SampleInterval  time.Duration
-	Matchers        map[string][]*labels.Matcher
-	OutputDirectory string
-	Parallelism     uint8
+	Host                *url.URL
+	HTTPConfig          promConfig.HTTPClientConfig
+	RateLimit           RateLimitConfig
+	SourceProtocol      SourceProtocol
+	SourceDirectory     string
+	Start               time.Time
+	End                 time.Time
+	SampleInterval      time.Duration
+	Matchers            map[string][]*labels.Matcher
+	MaxSeriesPerRequest uint
+	DedupLabels         []string
+	RelabelConfig       RelabelConfig
+	RemoteWriteConfig   *prometheusConfig.RemoteWriteConfig
+	OutputDirectory     string
+	Parallelism         uint8
+	BlockWriter         BlockWriterConfig
 }
@@ -0,0 +1,10 @@
+package config
+
+import "time"
+
+// RateLimitConfig represents the limits applied to the requests sent to a remote host.
+type RateLimitConfig struct {
+	RequestsPerSecond float64
+	SamplesPerSecond  float64
+	SlowdownLatency   time.Duration
+}
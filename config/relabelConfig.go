@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+	"os"
+)
+
+type RelabelConfig struct {
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+}
+
+type relabelConfigValue RelabelConfig
+
+func NewRelabelConfigValue(p *RelabelConfig) *relabelConfigValue {
+	*p = RelabelConfig{}
+	return (*relabelConfigValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *relabelConfigValue) String() string {
+	if len(e.RelabelConfigs) == 0 {
+		return "None"
+	}
+	return fmt.Sprintf("%d relabel configs", len(e.RelabelConfigs))
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *relabelConfigValue) Set(v string) error {
+	var relabelConfig RelabelConfig
+	if _, err := os.Stat(v); err != nil {
+		return errors.Wrap(err, "could not find file %s", v)
+	}
+	yamlFile, err := os.ReadFile(v)
+	if err != nil {
+		return errors.Wrap(err, "could not read file %s", v)
+	}
+	err = yaml.UnmarshalStrict(yamlFile, &relabelConfig)
+	if err != nil {
+		return errors.Wrap(err, "could not parse file %s", v)
+	}
+	*e = relabelConfigValue(relabelConfig)
+	return nil
+}
+
+// Type is only used in help text
+func (e *relabelConfigValue) Type() string {
+	return "relabelConfig"
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	prometheusConfig "github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+)
+
+type remoteWriteConfigValue struct {
+	value **prometheusConfig.RemoteWriteConfig
+	file  string
+}
+
+func NewRemoteWriteConfigValue(p **prometheusConfig.RemoteWriteConfig) *remoteWriteConfigValue {
+	rwcv := new(remoteWriteConfigValue)
+	rwcv.value = p
+	*rwcv.value = nil
+	return rwcv
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *remoteWriteConfigValue) String() string {
+	if e.file == "" {
+		return "None"
+	}
+	return e.file
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *remoteWriteConfigValue) Set(v string) error {
+	var remoteWriteConfig prometheusConfig.RemoteWriteConfig
+	if _, err := os.Stat(v); err != nil {
+		return errors.Wrap(err, "could not find file %s", v)
+	}
+	yamlFile, err := os.ReadFile(v)
+	if err != nil {
+		return errors.Wrap(err, "could not read file %s", v)
+	}
+	err = yaml.UnmarshalStrict(yamlFile, &remoteWriteConfig)
+	if err != nil {
+		return errors.Wrap(err, "could not parse file %s", v)
+	}
+	dir, err := filepath.Abs(filepath.Dir(v))
+	if err != nil {
+		return errors.Wrap(err, "could not determine directory of file %s", v)
+	}
+	remoteWriteConfig.SetDirectory(dir)
+	*e.value = &remoteWriteConfig
+	e.file = v
+	return nil
+}
+
+// Type is only used in help text
+func (e *remoteWriteConfigValue) Type() string {
+	return "remoteWriteConfig"
+}
@@ -0,0 +1,7 @@
+package config
+
+// RepairConfig represents the configuration of the repair command.
+type RepairConfig struct {
+	Directory string
+	DryRun    bool
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	"strings"
+)
+
+type ReportFormat string
+
+const (
+	TextReportFormat ReportFormat = "text"
+	JSONReportFormat ReportFormat = "json"
+	CSVReportFormat  ReportFormat = "csv"
+)
+
+var (
+	reportFormats = []ReportFormat{TextReportFormat, JSONReportFormat, CSVReportFormat}
+)
+
+type reportFormatValue ReportFormat
+
+func NewReportFormatValue(p *ReportFormat, val ReportFormat) *reportFormatValue {
+	*p = val
+	return (*reportFormatValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *reportFormatValue) String() string {
+	return string(*e)
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *reportFormatValue) Set(v string) error {
+	for _, f := range reportFormats {
+		if strings.EqualFold(string(f), v) {
+			*e = reportFormatValue(f)
+			return nil
+		}
+	}
+	return errors.New("report format must be one of %s", reportFormats)
+}
+
+// Type is only used in help text
+func (e *reportFormatValue) Type() string {
+	return "reportFormat"
+}
@@ -0,0 +1,9 @@
+package config
+
+// RewriteConfig represents the configuration of the rewrite command.
+type RewriteConfig struct {
+	Directory       string
+	Mapping         RewriteMapping
+	BackupDirectory string
+	DryRun          bool
+}
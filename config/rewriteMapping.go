@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+	"os"
+)
+
+type RewriteMapping struct {
+	MetricRenames  map[string]string `yaml:"metric_renames,omitempty"`
+	LabelRenames   map[string]string `yaml:"label_renames,omitempty"`
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs,omitempty"`
+}
+
+type rewriteMappingValue RewriteMapping
+
+func NewRewriteMappingValue(p *RewriteMapping) *rewriteMappingValue {
+	*p = RewriteMapping{}
+	return (*rewriteMappingValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *rewriteMappingValue) String() string {
+	if len(e.MetricRenames) == 0 && len(e.LabelRenames) == 0 && len(e.RelabelConfigs) == 0 {
+		return "None"
+	}
+	return fmt.Sprintf("%d metric renames, %d label renames, %d relabel configs", len(e.MetricRenames),
+		len(e.LabelRenames), len(e.RelabelConfigs))
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *rewriteMappingValue) Set(v string) error {
+	var mapping RewriteMapping
+	if _, err := os.Stat(v); err != nil {
+		return errors.Wrap(err, "could not find file %s", v)
+	}
+	yamlFile, err := os.ReadFile(v)
+	if err != nil {
+		return errors.Wrap(err, "could not read file %s", v)
+	}
+	err = yaml.UnmarshalStrict(yamlFile, &mapping)
+	if err != nil {
+		return errors.Wrap(err, "could not parse file %s", v)
+	}
+	for from, to := range mapping.MetricRenames {
+		if !model.IsValidLegacyMetricName(to) {
+			return errors.New("invalid metric name '%s' for metric '%s' in file %s", to, from, v)
+		}
+	}
+	for from, to := range mapping.LabelRenames {
+		if from == model.MetricNameLabel || to == model.MetricNameLabel {
+			return errors.New("metric names must be renamed with metric_renames in file %s", v)
+		}
+		if !model.LabelName(to).IsValidLegacy() {
+			return errors.New("invalid label name '%s' for label '%s' in file %s", to, from, v)
+		}
+	}
+	*e = rewriteMappingValue(mapping)
+	return nil
+}
+
+// Type is only used in help text
+func (e *rewriteMappingValue) Type() string {
+	return "rewriteMapping"
+}
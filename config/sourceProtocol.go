@@ -0,0 +1,45 @@
+package config
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	"strings"
+)
+
+type SourceProtocol string
+
+const (
+	RemoteReadSourceProtocol SourceProtocol = "remote_read"
+	QueryRangeSourceProtocol SourceProtocol = "query_range"
+)
+
+var (
+	sourceProtocols = []SourceProtocol{RemoteReadSourceProtocol, QueryRangeSourceProtocol}
+)
+
+type sourceProtocolValue SourceProtocol
+
+func NewSourceProtocolValue(p *SourceProtocol, val SourceProtocol) *sourceProtocolValue {
+	*p = val
+	return (*sourceProtocolValue)(p)
+}
+
+// String is used both by fmt.Print and by Cobra in help text
+func (e *sourceProtocolValue) String() string {
+	return string(*e)
+}
+
+// Set must have pointer receiver, so it doesn't change the value of a copy
+func (e *sourceProtocolValue) Set(v string) error {
+	for _, p := range sourceProtocols {
+		if strings.EqualFold(string(p), v) {
+			*e = sourceProtocolValue(p)
+			return nil
+		}
+	}
+	return errors.New("source protocol must be one of %s", sourceProtocols)
+}
+
+// Type is only used in help text
+func (e *sourceProtocolValue) Type() string {
+	return "sourceProtocol"
+}
@@ -0,0 +1,6 @@
+package config
+
+// VerifyConfig represents the configuration of the verify command.
+type VerifyConfig struct {
+	Directory string
+}
@@ -0,0 +1,14 @@
+package config
+
+import (
+	prometheusConfig "github.com/prometheus/prometheus/config"
+)
+
+// WalToBlocksConfig represents the configuration of the wal-to-blocks command.
+type WalToBlocksConfig struct {
+	SourceDirectory   string
+	RemoteWriteConfig *prometheusConfig.RemoteWriteConfig
+	OutputDirectory   string
+	Parallelism       uint8
+	BlockWriter       BlockWriterConfig
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	promConfig "github.com/prometheus/common/config"
 	"net/url"
 	"time"
 )
@@ -9,6 +10,8 @@ import (
 type WebConfig struct {
 	ListenAddress  ListenAddress
 	Host           *url.URL
+	HTTPConfig     promConfig.HTTPClientConfig
+	RateLimit      RateLimitConfig
 	SampleInterval time.Duration
 	Parallelism    uint8
 }
@@ -0,0 +1,234 @@
+package analyzer
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"os"
+	"sort"
+)
+
+func NewAnalyzer() command.Task[config.AnalyzeConfig] {
+	return &analyzer{}
+}
+
+type analyzer struct {
+}
+
+func (t *analyzer) Run(c *config.AnalyzeConfig) error {
+	matchers := c.Matchers
+	if len(matchers) == 0 {
+		matchers = map[string][]*labels.Matcher{
+			"all": {labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")},
+		}
+	}
+	var expressions []string
+	for expression := range matchers {
+		expressions = append(expressions, expression)
+	}
+	sort.Strings(expressions)
+
+	db, err := database.NewReadOnlyDatabase(c.Directory)
+	if err != nil {
+		return errors.Wrap(err, "failed to open db")
+	}
+	defer func(db database.ReadOnlyDatabase) {
+		_ = db.Close()
+	}(db)
+
+	blocks := db.Blocks()
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Meta().MinTime < blocks[j].Meta().MinTime
+	})
+
+	report := &Report{}
+	overall := newCollector()
+	churn := &Churn{}
+	var previous *collector
+	for _, b := range blocks {
+		current := newCollector()
+		if err = current.collect(b, expressions, matchers); err != nil {
+			return errors.Wrap(err, "failed to analyze block %s", b.Meta().ULID)
+		}
+		blockStats := current.statistics(b.Meta().MinTime, b.Meta().MaxTime, int(c.Limit))
+		blockStats.Block = b.Meta().ULID.String()
+		if previous != nil {
+			blockStats.Churn = current.churn(previous)
+			churn.Added += blockStats.Churn.Added
+			churn.Removed += blockStats.Churn.Removed
+		}
+		report.Blocks = append(report.Blocks, blockStats)
+		overall.merge(current)
+		previous = current
+	}
+
+	if len(blocks) > 0 {
+		maxTime := blocks[0].Meta().MaxTime
+		for _, b := range blocks[1:] {
+			maxTime = common.MaxInt64(maxTime, b.Meta().MaxTime)
+		}
+		report.Overall = overall.statistics(blocks[0].Meta().MinTime, maxTime, int(c.Limit))
+		report.Overall.Block = overallScope
+		report.Overall.Churn = churn
+	}
+	return newReportWriter(c.Format).Write(os.Stdout, report)
+}
+
+type seriesInfo struct {
+	metric  labels.Labels
+	samples int64
+	chunks  int64
+}
+
+// collector gathers the series, and their number of samples and chunks, of one or more blocks.
+type collector struct {
+	series map[uint64]*seriesInfo
+}
+
+func newCollector() *collector {
+	return &collector{
+		series: map[uint64]*seriesInfo{},
+	}
+}
+
+func (c *collector) collect(b tsdb.BlockReader, expressions []string, matchers map[string][]*labels.Matcher) error {
+	meta := b.Meta()
+	q, err := tsdb.NewBlockChunkQuerier(b, meta.MinTime, meta.MaxTime)
+	if err != nil {
+		return errors.Wrap(err, "failed to create querier")
+	}
+	defer func(q storage.ChunkQuerier) {
+		_ = q.Close()
+	}(q)
+
+	for _, expression := range expressions {
+		ss := q.Select(false, nil, matchers[expression]...)
+		for ss.Next() {
+			s := ss.At()
+			hash := s.Labels().Hash()
+			if _, ok := c.series[hash]; ok {
+				continue
+			}
+			info := &seriesInfo{metric: s.Labels()}
+			it := s.Iterator()
+			for it.Next() {
+				info.chunks++
+				info.samples += int64(it.At().Chunk.NumSamples())
+			}
+			if err = it.Err(); err != nil {
+				return errors.Wrap(err, "failed to read chunks of %s", s.Labels())
+			}
+			if info.samples > 0 {
+				c.series[hash] = info
+			}
+		}
+		if err = ss.Err(); err != nil {
+			return errors.Wrap(err, "failed to select '%s'", expression)
+		}
+	}
+	return nil
+}
+
+func (c *collector) merge(other *collector) {
+	for hash, s := range other.series {
+		if existing, ok := c.series[hash]; ok {
+			existing.samples += s.samples
+			existing.chunks += s.chunks
+		} else {
+			c.series[hash] = &seriesInfo{metric: s.metric, samples: s.samples, chunks: s.chunks}
+		}
+	}
+}
+
+// churn returns the number of series which were added and removed compared to the previous block.
+func (c *collector) churn(previous *collector) *Churn {
+	churn := &Churn{}
+	for hash := range c.series {
+		if _, ok := previous.series[hash]; !ok {
+			churn.Added++
+		}
+	}
+	for hash := range previous.series {
+		if _, ok := c.series[hash]; !ok {
+			churn.Removed++
+		}
+	}
+	return churn
+}
+
+func (c *collector) statistics(minTime int64, maxTime int64, limit int) Statistics {
+	stats := Statistics{
+		MinTime: minTime,
+		MaxTime: maxTime,
+		Series:  len(c.series),
+	}
+	metrics := map[string]int{}
+	labelValues := map[string]map[string]int{}
+	for _, s := range c.series {
+		stats.Samples += s.samples
+		stats.Chunks += s.chunks
+		if stats.SamplesPerSeries.Min == 0 || s.samples < stats.SamplesPerSeries.Min {
+			stats.SamplesPerSeries.Min = s.samples
+		}
+		if s.samples > stats.SamplesPerSeries.Max {
+			stats.SamplesPerSeries.Max = s.samples
+		}
+		for _, l := range s.metric {
+			if l.Name == labels.MetricName {
+				metrics[l.Value]++
+			}
+			values, ok := labelValues[l.Name]
+			if !ok {
+				values = map[string]int{}
+				labelValues[l.Name] = values
+			}
+			values[l.Value]++
+		}
+	}
+	if stats.Series > 0 {
+		stats.SamplesPerSeries.Avg = float64(stats.Samples) / float64(stats.Series)
+	}
+
+	for name, count := range metrics {
+		stats.Metrics = append(stats.Metrics, Count{Name: name, Series: count})
+	}
+	var pairs []Count
+	for name, values := range labelValues {
+		label := LabelCardinality{Name: name, Values: len(values)}
+		for value, count := range values {
+			label.Series += count
+			pairs = append(pairs, Count{Name: name + "=" + value, Series: count})
+		}
+		stats.LabelNames = append(stats.LabelNames, label)
+	}
+	stats.Metrics = topCounts(stats.Metrics, limit)
+	stats.LabelValues = topCounts(pairs, limit)
+	sort.Slice(stats.LabelNames, func(i, j int) bool {
+		if stats.LabelNames[i].Values != stats.LabelNames[j].Values {
+			return stats.LabelNames[i].Values > stats.LabelNames[j].Values
+		}
+		return stats.LabelNames[i].Name < stats.LabelNames[j].Name
+	})
+	if limit > 0 && len(stats.LabelNames) > limit {
+		stats.LabelNames = stats.LabelNames[:limit]
+	}
+	return stats
+}
+
+func topCounts(counts []Count, limit int) []Count {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Series != counts[j].Series {
+			return counts[i].Series > counts[j].Series
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if limit > 0 && len(counts) > limit {
+		return counts[:limit]
+	}
+	return counts
+}
@@ -0,0 +1,165 @@
+package analyzer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+const (
+	overallScope = "overall"
+)
+
+type Report struct {
+	Blocks  []Statistics `json:"blocks"`
+	Overall Statistics   `json:"overall"`
+}
+
+type Statistics struct {
+	Block            string             `json:"block"`
+	MinTime          int64              `json:"minTime"`
+	MaxTime          int64              `json:"maxTime"`
+	Series           int                `json:"series"`
+	Samples          int64              `json:"samples"`
+	Chunks           int64              `json:"chunks"`
+	SamplesPerSeries SamplesPerSeries   `json:"samplesPerSeries"`
+	Churn            *Churn             `json:"churn,omitempty"`
+	Metrics          []Count            `json:"metrics"`
+	LabelNames       []LabelCardinality `json:"labelNames"`
+	LabelValues      []Count            `json:"labelValues"`
+}
+
+type SamplesPerSeries struct {
+	Min int64   `json:"min"`
+	Avg float64 `json:"avg"`
+	Max int64   `json:"max"`
+}
+
+// Churn is the number of series which were added and removed compared to the previous block.
+type Churn struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+type Count struct {
+	Name   string `json:"name"`
+	Series int    `json:"series"`
+}
+
+type LabelCardinality struct {
+	Name   string `json:"name"`
+	Values int    `json:"values"`
+	Series int    `json:"series"`
+}
+
+type reportWriter interface {
+	Write(w io.Writer, report *Report) error
+}
+
+func newReportWriter(format config.ReportFormat) reportWriter {
+	switch format {
+	case config.JSONReportFormat:
+		return &jsonReportWriter{}
+	case config.CSVReportFormat:
+		return &csvReportWriter{}
+	default:
+		return &textReportWriter{}
+	}
+}
+
+type textReportWriter struct {
+}
+
+func (r *textReportWriter) Write(w io.Writer, report *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range report.Blocks {
+		r.writeStatistics(tw, "Block "+s.Block, s)
+	}
+	if len(report.Blocks) > 1 {
+		r.writeStatistics(tw, "Overall", report.Overall)
+	}
+	return errors.Wrap(tw.Flush(), "failed to write report")
+}
+
+func (r *textReportWriter) writeStatistics(w io.Writer, title string, s Statistics) {
+	_, _ = fmt.Fprintf(w, "%s from %s\n", title, common.FormatDateRange(s.MinTime, s.MaxTime))
+	_, _ = fmt.Fprintf(w, "  Series:\t%d\n", s.Series)
+	_, _ = fmt.Fprintf(w, "  Samples:\t%d\n", s.Samples)
+	_, _ = fmt.Fprintf(w, "  Chunks:\t%d\n", s.Chunks)
+	_, _ = fmt.Fprintf(w, "  Samples per series:\tmin %d, avg %.1f, max %d\n", s.SamplesPerSeries.Min,
+		s.SamplesPerSeries.Avg, s.SamplesPerSeries.Max)
+	if s.Churn != nil {
+		_, _ = fmt.Fprintf(w, "  Churn:\t%d added, %d removed\n", s.Churn.Added, s.Churn.Removed)
+	}
+	_, _ = fmt.Fprintf(w, "  Top metrics by series:\n")
+	for _, m := range s.Metrics {
+		_, _ = fmt.Fprintf(w, "    %s\t%d\n", m.Name, m.Series)
+	}
+	_, _ = fmt.Fprintf(w, "  Top label names by cardinality:\n")
+	for _, l := range s.LabelNames {
+		_, _ = fmt.Fprintf(w, "    %s\t%d values\t%d series\n", l.Name, l.Values, l.Series)
+	}
+	_, _ = fmt.Fprintf(w, "  Top label values by series:\n")
+	for _, l := range s.LabelValues {
+		_, _ = fmt.Fprintf(w, "    %s\t%d\n", l.Name, l.Series)
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+type jsonReportWriter struct {
+}
+
+func (r *jsonReportWriter) Write(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(report), "failed to write report")
+}
+
+// csvReportWriter writes one row per statistic, so the blocks and the overall statistics share the same columns.
+type csvReportWriter struct {
+}
+
+func (r *csvReportWriter) Write(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"block", "statistic", "name", "value"})
+	for _, s := range report.Blocks {
+		r.writeStatistics(cw, s)
+	}
+	r.writeStatistics(cw, report.Overall)
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "failed to write report")
+}
+
+func (r *csvReportWriter) writeStatistics(cw *csv.Writer, s Statistics) {
+	write := func(statistic string, name string, value string) {
+		_ = cw.Write([]string{s.Block, statistic, name, value})
+	}
+	write("minTime", "", common.FormatDate(s.MinTime))
+	write("maxTime", "", common.FormatDate(s.MaxTime))
+	write("series", "", strconv.Itoa(s.Series))
+	write("samples", "", strconv.FormatInt(s.Samples, 10))
+	write("chunks", "", strconv.FormatInt(s.Chunks, 10))
+	write("minSamplesPerSeries", "", strconv.FormatInt(s.SamplesPerSeries.Min, 10))
+	write("avgSamplesPerSeries", "", strconv.FormatFloat(s.SamplesPerSeries.Avg, 'f', 1, 64))
+	write("maxSamplesPerSeries", "", strconv.FormatInt(s.SamplesPerSeries.Max, 10))
+	if s.Churn != nil {
+		write("addedSeries", "", strconv.Itoa(s.Churn.Added))
+		write("removedSeries", "", strconv.Itoa(s.Churn.Removed))
+	}
+	for _, m := range s.Metrics {
+		write("metricSeries", m.Name, strconv.Itoa(m.Series))
+	}
+	for _, l := range s.LabelNames {
+		write("labelValues", l.Name, strconv.Itoa(l.Values))
+		write("labelSeries", l.Name, strconv.Itoa(l.Series))
+	}
+	for _, l := range s.LabelValues {
+		write("labelValueSeries", l.Name, strconv.Itoa(l.Series))
+	}
+}
@@ -28,7 +28,7 @@ func (t *backfiller) Run(c *config.BackfillConfig) error {
 		return errors.New("no recording rules left after filtering")
 	}
 
-	qdb, err := database.NewDatabase(c.Directory, database.DefaultBlockDuration, database.DefaultRetention,
+	qdb, err := database.NewDatabase(c.Directory, database.DefaultBlockDuration, database.DefaultRetention, 0,
 		context.Background())
 	if err != nil {
 		return errors.Wrap(err, "failed to open query db")
@@ -42,10 +42,11 @@ func (t *backfiller) Run(c *config.BackfillConfig) error {
 		return errors.Wrap(err, "failed to get query manager")
 	}
 
-	plannerConfig := block.NewPlannerConfig(c.Directory, c.Start, c.End, c.SampleInterval, int(c.Parallelism))
+	plannerConfig := block.NewPlannerConfig(c.Directory, c.Start, c.End, c.SampleInterval, int(c.Parallelism), block.WriterOptions(c.BlockWriter))
 	generator := &planGenerator{recordingRules: recordingRules}
 	executorCreator := &planExecutorCreator{queryManager: queryManager}
-	writer := block.NewPlannedBlockWriter[config.RecordingRule](plannerConfig, generator, executorCreator)
+	output := block.NewOutput(plannerConfig, c.RemoteWriteConfig)
+	writer := block.NewPlannedBlockWriter[config.RecordingRule](plannerConfig, output, generator, executorCreator)
 	return writer.Run()
 }
 
@@ -0,0 +1,92 @@
+package block
+
+import (
+	"time"
+)
+
+// chunkSplitter decides the duration of the chunks a block is split into.  Each chunk is planned as an entry for every
+// plan data, so the chunk duration determines how much work a plan entry does.
+type chunkSplitter interface {
+	chunkDuration(blockDuration int64) int64
+	// observe records the work done for a block, whose entries covered entryDuration milliseconds in total.
+	observe(entryDuration int64, samples uint64, runtime time.Duration)
+}
+
+func newChunkSplitter(c PlannerConfig) chunkSplitter {
+	switch c.ChunkStrategy() {
+	case DurationChunkStrategy:
+		return &durationChunkSplitter{duration: c.ChunkDuration().Milliseconds()}
+	case SamplesChunkStrategy:
+		return &adaptiveChunkSplitter{
+			target: float64(c.TargetSamples()),
+			cost: func(samples uint64, _ time.Duration) float64 {
+				return float64(samples)
+			},
+		}
+	case AdaptiveChunkStrategy:
+		return &adaptiveChunkSplitter{
+			target: float64(c.TargetEntryRuntime()),
+			cost: func(_ uint64, runtime time.Duration) float64 {
+				return float64(runtime)
+			},
+		}
+	default:
+		return &countChunkSplitter{count: chunksPerBlock}
+	}
+}
+
+// countChunkSplitter splits blocks into a fixed number of chunks.
+type countChunkSplitter struct {
+	count int64
+}
+
+func (s *countChunkSplitter) chunkDuration(blockDuration int64) int64 {
+	return blockDuration / s.count
+}
+
+func (s *countChunkSplitter) observe(int64, uint64, time.Duration) {
+}
+
+// durationChunkSplitter splits blocks into chunks of a fixed duration.
+type durationChunkSplitter struct {
+	duration int64
+}
+
+func (s *durationChunkSplitter) chunkDuration(int64) int64 {
+	return s.duration
+}
+
+func (s *durationChunkSplitter) observe(int64, uint64, time.Duration) {
+}
+
+// adaptiveChunkSplitter sizes the chunks so that each plan entry has the target cost, based on the cost per millisecond
+// of the previous blocks.  The first block is split like the count strategy does, and blocks are not split while no cost
+// was observed.
+type adaptiveChunkSplitter struct {
+	target    float64
+	cost      func(samples uint64, runtime time.Duration) float64
+	costPerMs float64
+	observed  bool
+}
+
+func (s *adaptiveChunkSplitter) chunkDuration(blockDuration int64) int64 {
+	if !s.observed {
+		return blockDuration / chunksPerBlock
+	}
+	if s.costPerMs <= 0 {
+		return blockDuration
+	}
+	return int64(s.target / s.costPerMs)
+}
+
+func (s *adaptiveChunkSplitter) observe(entryDuration int64, samples uint64, runtime time.Duration) {
+	if entryDuration <= 0 {
+		return
+	}
+	costPerMs := s.cost(samples, runtime) / float64(entryDuration)
+	if s.observed {
+		costPerMs = (s.costPerMs + costPerMs) / 2
+	}
+	s.costPerMs = costPerMs
+	s.observed = true
+}
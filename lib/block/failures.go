@@ -0,0 +1,96 @@
+package block
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	"k8s.io/klog/v2"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// entryError is the error of a plan entry which failed on every attempt.
+type entryError struct {
+	entry    string
+	data     string
+	start    int64
+	end      int64
+	attempts int
+	err      error
+}
+
+func (e *entryError) Error() string {
+	return fmt.Sprintf("failed to run %s after %d attempts: %v", e.entry, e.attempts, e.err)
+}
+
+func (e *entryError) Unwrap() error {
+	return e.err
+}
+
+// failureReport collects the errors of the failed plan entries.
+type failureReport struct {
+	mtx      sync.Mutex
+	failures []error
+}
+
+func (r *failureReport) add(err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.failures = append(r.failures, err)
+}
+
+func (r *failureReport) err() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	switch len(r.failures) {
+	case 0:
+		return nil
+	case 1:
+		return r.failures[0]
+	default:
+		return errors.New("%d plan entries failed, the first with: %v", len(r.failures), r.failures[0])
+	}
+}
+
+func (r *failureReport) log() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, f := range r.failures {
+		klog.V(0).Infof("Failed plan entry: %v", f)
+	}
+}
+
+// write writes the failed plan entries as CSV to the file, unless the file is empty.  The data and time range of each
+// entry can be used to run it again.
+func (r *failureReport) write(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to create failed entries report: %s", filename)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"data", "start", "end", "attempts", "error"}); err != nil {
+		return errors.Wrap(err, "failed to write failed entries report: %s", filename)
+	}
+	for _, failure := range r.failures {
+		record := []string{"", "", "", "", failure.Error()}
+		if e, ok := failure.(*entryError); ok {
+			record = []string{e.data, common.FormatDate(e.start), common.FormatDate(e.end), strconv.Itoa(e.attempts),
+				e.err.Error()}
+		}
+		if err = w.Write(record); err != nil {
+			return errors.Wrap(err, "failed to write failed entries report: %s", filename)
+		}
+	}
+	w.Flush()
+	return errors.Wrap(w.Error(), "failed to write failed entries report: %s", filename)
+}
@@ -0,0 +1,33 @@
+package block
+
+import (
+	"time"
+)
+
+// ChunkStrategy decides how blocks are split into the chunks planned for each entry.
+type ChunkStrategy string
+
+const (
+	CountChunkStrategy    ChunkStrategy = "count"
+	DurationChunkStrategy ChunkStrategy = "duration"
+	SamplesChunkStrategy  ChunkStrategy = "samples"
+	AdaptiveChunkStrategy ChunkStrategy = "adaptive"
+)
+
+// WriterOptions configures the output of a PlannedBlockWriter, and how its plan entries are split and retried.  It has
+// the fields of the block writer flags, so their config can be converted to it.
+type WriterOptions struct {
+	Strict             bool
+	MaxMemory          int64
+	ExternalLabels     map[string]string
+	BucketDirectory    string
+	BlockDuration      time.Duration
+	ChunkStrategy      string
+	ChunkDuration      time.Duration
+	TargetSamples      uint
+	TargetEntryRuntime time.Duration
+	Retries            uint
+	RetryBackoff       time.Duration
+	ContinueOnError    bool
+	FailedEntriesFile  string
+}
@@ -0,0 +1,120 @@
+package block
+
+import (
+	"context"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/kadaan/promutil/lib/remote"
+	prometheusConfig "github.com/prometheus/prometheus/config"
+)
+
+// Output is the destination of the samples appended by a PlannedBlockWriter.
+type Output interface {
+	AppendManager() (database.AppendManager, error)
+	// Sequential reports whether the samples of a series must be appended in time order, so the plan entries are
+	// executed one at a time.
+	Sequential() bool
+	Commit() error
+	Close() error
+}
+
+// NewOutput creates an Output which writes to the remote write endpoint when remoteWriteConfig is provided,
+// and to TSDB blocks in the configured output directory otherwise.
+func NewOutput(config PlannerConfig, remoteWriteConfig *prometheusConfig.RemoteWriteConfig) Output {
+	if remoteWriteConfig != nil {
+		return &remoteWriteOutput{config: remoteWriteConfig}
+	}
+	return &localOutput{config: config}
+}
+
+type localOutput struct {
+	config        PlannerConfig
+	tempDirectory string
+	db            database.Database
+	appendManager database.AppendManager
+}
+
+func (o *localOutput) AppendManager() (database.AppendManager, error) {
+	blockDuration := o.config.OutputBlockDuration()
+	tempDirectory, err := database.NewTempDirectory(o.config.OutputDirectory(), tmpGenerateDirSuffix)
+	if err != nil {
+		return nil, err
+	}
+	o.tempDirectory = tempDirectory
+
+	o.db, err = database.NewDatabase(tempDirectory, blockDuration, database.DefaultRetention, o.config.MaxMemory(),
+		context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open db")
+	}
+
+	o.appendManager, err = o.db.AppendManager()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get appender")
+	}
+	return o.appendManager, nil
+}
+
+func (o *localOutput) Sequential() bool {
+	return false
+}
+
+func (o *localOutput) Commit() error {
+	err := o.appendManager.Close()
+	if err != nil {
+		return err
+	}
+
+	err = o.db.Compact()
+	if err != nil {
+		return err
+	}
+
+	if len(o.config.ExternalLabels()) > 0 || o.config.BucketLayout() {
+		err = database.WriteThanosMeta(o.tempDirectory, database.ThanosConfig{
+			ExternalLabels: o.config.ExternalLabels(),
+			BucketLayout:   o.config.BucketLayout(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return database.MoveBlocks(o.tempDirectory, o.config.OutputDirectory())
+}
+
+func (o *localOutput) Close() error {
+	if o.db == nil {
+		return nil
+	}
+	return o.db.Close()
+}
+
+type remoteWriteOutput struct {
+	config        *prometheusConfig.RemoteWriteConfig
+	appendManager database.AppendManager
+}
+
+func (o *remoteWriteOutput) AppendManager() (database.AppendManager, error) {
+	appendManager, err := remote.NewWriteManager(o.config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create remote write queue")
+	}
+	o.appendManager = appendManager
+	return appendManager, nil
+}
+
+func (o *remoteWriteOutput) Sequential() bool {
+	return true
+}
+
+func (o *remoteWriteOutput) Commit() error {
+	return o.appendManager.Close()
+}
+
+func (o *remoteWriteOutput) Close() error {
+	if o.appendManager == nil {
+		return nil
+	}
+	return o.appendManager.Close()
+}
@@ -3,10 +3,12 @@ package block
 import (
 	"context"
 	"fmt"
+	"github.com/cenkalti/backoff"
 	"github.com/kadaan/promutil/lib/common"
 	"github.com/kadaan/promutil/lib/database"
 	"github.com/kadaan/promutil/lib/errors"
 	"k8s.io/klog/v2"
+	"os"
 	"runtime"
 	"sync"
 	"time"
@@ -14,6 +16,7 @@ import (
 
 const (
 	tmpGenerateDirSuffix = ".tmp-for-generate"
+	chunksPerBlock       = 4
 )
 
 var (
@@ -70,11 +73,24 @@ type PlannerConfig interface {
 	StartTime() time.Time
 	EndTime() time.Time
 	BlockDuration() int64
+	OutputBlockDuration() int64
 	SampleInterval() time.Duration
 	Parallelism() uint8
-}
-
-func NewPlannerConfig(outputDirectory string, startTime time.Time, endTime time.Time, sampleInterval time.Duration, parallelism int) PlannerConfig {
+	Strict() bool
+	MaxMemory() int64
+	ExternalLabels() map[string]string
+	BucketLayout() bool
+	ChunkStrategy() ChunkStrategy
+	ChunkDuration() time.Duration
+	TargetSamples() uint64
+	TargetEntryRuntime() time.Duration
+	Retries() uint
+	RetryBackoff() time.Duration
+	ContinueOnError() bool
+	FailedEntriesFile() string
+}
+
+func NewPlannerConfig(outputDirectory string, startTime time.Time, endTime time.Time, sampleInterval time.Duration, parallelism int, writerConfig WriterOptions) PlannerConfig {
 	var prl uint8 = 1
 	if parallelism > 0 {
 		if parallelism <= int(MaxParallelism) {
@@ -83,21 +99,51 @@ func NewPlannerConfig(outputDirectory string, startTime time.Time, endTime time.
 			prl = MaxParallelism
 		}
 	}
+	bucketLayout := writerConfig.BucketDirectory != ""
+	if bucketLayout {
+		outputDirectory = writerConfig.BucketDirectory
+	}
 	return &plannerConfig{
-		outputDirectory: outputDirectory,
-		startTime:       startTime,
-		endTime:         endTime,
-		sampleInterval:  sampleInterval,
-		parallelism:     prl,
+		outputDirectory:    outputDirectory,
+		startTime:          startTime,
+		endTime:            endTime,
+		sampleInterval:     sampleInterval,
+		parallelism:        prl,
+		strict:             writerConfig.Strict,
+		maxMemory:          writerConfig.MaxMemory,
+		externalLabels:     writerConfig.ExternalLabels,
+		bucketLayout:       bucketLayout,
+		blockDuration:      writerConfig.BlockDuration.Milliseconds(),
+		chunkStrategy:      ChunkStrategy(writerConfig.ChunkStrategy),
+		chunkDuration:      writerConfig.ChunkDuration,
+		targetSamples:      uint64(writerConfig.TargetSamples),
+		targetEntryRuntime: writerConfig.TargetEntryRuntime,
+		retries:            writerConfig.Retries,
+		retryBackoff:       writerConfig.RetryBackoff,
+		continueOnError:    writerConfig.ContinueOnError,
+		failedEntriesFile:  writerConfig.FailedEntriesFile,
 	}
 }
 
 type plannerConfig struct {
-	outputDirectory string
-	startTime       time.Time
-	endTime         time.Time
-	sampleInterval  time.Duration
-	parallelism     uint8
+	outputDirectory    string
+	startTime          time.Time
+	endTime            time.Time
+	sampleInterval     time.Duration
+	parallelism        uint8
+	strict             bool
+	maxMemory          int64
+	externalLabels     map[string]string
+	bucketLayout       bool
+	blockDuration      int64
+	chunkStrategy      ChunkStrategy
+	chunkDuration      time.Duration
+	targetSamples      uint64
+	targetEntryRuntime time.Duration
+	retries            uint
+	retryBackoff       time.Duration
+	continueOnError    bool
+	failedEntriesFile  string
 }
 
 func (c plannerConfig) OutputDirectory() string {
@@ -112,10 +158,25 @@ func (c plannerConfig) EndTime() time.Time {
 	return c.endTime
 }
 
+// BlockDuration returns the duration of the blocks which are planned one at a time.
 func (c plannerConfig) BlockDuration() int64 {
+	if c.blockDuration > 0 {
+		return c.blockDuration
+	}
 	return database.DefaultBlockDuration
 }
 
+// OutputBlockDuration returns the duration of the written blocks, which is chosen from the time range when it isn't
+// configured.
+func (c plannerConfig) OutputBlockDuration() int64 {
+	if c.blockDuration > 0 {
+		return c.blockDuration
+	}
+	startInMs := c.startTime.Unix() * int64(time.Second/time.Millisecond)
+	endInMs := c.endTime.Unix() * int64(time.Second/time.Millisecond)
+	return database.GetCompatibleBlockDuration(endInMs - startInMs)
+}
+
 func (c plannerConfig) SampleInterval() time.Duration {
 	return c.sampleInterval
 }
@@ -124,22 +185,93 @@ func (c plannerConfig) Parallelism() uint8 {
 	return c.parallelism
 }
 
+func (c plannerConfig) Strict() bool {
+	return c.strict
+}
+
+func (c plannerConfig) MaxMemory() int64 {
+	return c.maxMemory
+}
+
+func (c plannerConfig) ExternalLabels() map[string]string {
+	return c.externalLabels
+}
+
+func (c plannerConfig) BucketLayout() bool {
+	return c.bucketLayout
+}
+
+func (c plannerConfig) ChunkStrategy() ChunkStrategy {
+	return c.chunkStrategy
+}
+
+func (c plannerConfig) ChunkDuration() time.Duration {
+	return c.chunkDuration
+}
+
+func (c plannerConfig) TargetSamples() uint64 {
+	return c.targetSamples
+}
+
+func (c plannerConfig) TargetEntryRuntime() time.Duration {
+	return c.targetEntryRuntime
+}
+
+func (c plannerConfig) Retries() uint {
+	return c.retries
+}
+
+func (c plannerConfig) RetryBackoff() time.Duration {
+	return c.retryBackoff
+}
+
+func (c plannerConfig) ContinueOnError() bool {
+	return c.continueOnError
+}
+
+func (c plannerConfig) FailedEntriesFile() string {
+	return c.failedEntriesFile
+}
+
 type Planner[V fmt.Stringer] interface {
-	Plan(transform func(int64, int64, int64) []PlanEntry[V]) [][]PlanEntry[V]
+	// Plan plans one block at a time, and passes its entries to the handler until the handler returns false.  Blocks are
+	// planned lazily, so the work observed for the previous blocks determines how the next block is split.
+	Plan(transform func(int64, int64, int64) []PlanEntry[V], handler func([]PlanEntry[V]) bool)
+	// Blocks returns the number of blocks which are planned.
+	Blocks() int
+	// Observe records the samples appended and the time spent executing the entries of a block.
+	Observe(plan []PlanEntry[V], samples uint64, runtime time.Duration)
 }
 
 func NewPlanner[V fmt.Stringer](config PlannerConfig) Planner[V] {
 	return &planner[V]{
-		config: config,
+		config:   config,
+		splitter: newChunkSplitter(config),
 	}
 }
 
 type planner[V fmt.Stringer] struct {
-	config PlannerConfig
+	config   PlannerConfig
+	splitter chunkSplitter
 }
 
-func (p *planner[V]) Plan(transform func(int64, int64, int64) []PlanEntry[V]) [][]PlanEntry[V] {
-	var results [][]PlanEntry[V]
+func (p *planner[V]) Plan(transform func(int64, int64, int64) []PlanEntry[V], handler func([]PlanEntry[V]) bool) {
+	stepDuration := int64(p.config.SampleInterval() / (time.Millisecond / time.Nanosecond))
+	p.forEachBlock(func(start time.Time, end time.Time) bool {
+		return handler(p.planBlock(start, end, stepDuration, transform))
+	})
+}
+
+func (p *planner[V]) Blocks() int {
+	blocks := 0
+	p.forEachBlock(func(time.Time, time.Time) bool {
+		blocks++
+		return true
+	})
+	return blocks
+}
+
+func (p *planner[V]) forEachBlock(f func(start time.Time, end time.Time) bool) {
 	startInMs := p.config.StartTime().Unix() * int64(time.Second/time.Millisecond)
 	endInMs := p.config.EndTime().Unix() * int64(time.Second/time.Millisecond)
 	blockStart := p.config.BlockDuration() * (startInMs / p.config.BlockDuration())
@@ -151,33 +283,51 @@ func (p *planner[V]) Plan(transform func(int64, int64, int64) []PlanEntry[V]) []
 		for startWithAlignment.Unix() < currStart {
 			startWithAlignment = startWithAlignment.Add(p.config.SampleInterval())
 		}
-		end := time.Unix(common.MinInt64(blockEnd/int64(time.Second/time.Millisecond), p.config.EndTime().Unix()), 0).UTC()
+		end := time.UnixMilli(common.MinInt64(blockEnd, p.config.EndTime().UnixMilli())).UTC()
 		if end.Equal(startWithAlignment) || end.Before(startWithAlignment) {
 			break
 		}
 
-		blockPlan := p.planBlock(startWithAlignment, end, stepDuration, transform)
-		results = append(results, blockPlan)
+		if !f(startWithAlignment, end) {
+			return
+		}
 	}
-	return results
+}
+
+func (p *planner[V]) Observe(plan []PlanEntry[V], samples uint64, runtime time.Duration) {
+	var entryDuration int64
+	for _, e := range plan {
+		entryDuration += e.End() - e.Start() + 1
+	}
+	p.splitter.observe(entryDuration, samples, runtime)
 }
 
 func (p *planner[V]) planBlock(blockStart time.Time, blockEnd time.Time, stepDuration int64, transform func(int64, int64, int64) []PlanEntry[V]) []PlanEntry[V] {
 	var plan []PlanEntry[V]
 	start := blockStart.UnixNano() / int64(time.Millisecond/time.Nanosecond)
 	end := blockEnd.UnixNano() / int64(time.Millisecond/time.Nanosecond)
-	chunkDuration := (end - start) / 4
+	chunkDuration := p.splitter.chunkDuration(end - start + 1)
+	if stepDuration > 1 && chunkDuration > 0 {
+		// Chunks are a multiple of the step, so the evaluated timestamps don't depend on the chunk duration.
+		chunkDuration = (chunkDuration + stepDuration - 1) / stepDuration * stepDuration
+	}
+	if chunkDuration < 1 || chunkDuration > end-start+1 {
+		chunkDuration = end - start + 1
+	}
 
-	chunkStart := start
-	for ; chunkStart <= end; chunkStart = chunkStart + chunkDuration {
+	// The last chunk ends at the end of the block, so the chunks cover every timestamp of the block.
+	for chunkStart := start; chunkStart <= end; {
 		chunkEnd := chunkStart + chunkDuration - 1
-		if chunkEnd > end {
-			break
+		if chunkEnd+chunkDuration > end {
+			chunkEnd = end
 		}
 		for _, pe := range transform(chunkStart, chunkEnd, stepDuration) {
 			plan = append(plan, pe)
 		}
+		chunkStart = chunkEnd + 1
 	}
+	klog.V(1).Infof("Planned %d entries for %s in chunks of %s", len(plan), common.FormatDateRange(start, end),
+		time.Duration(chunkDuration)*time.Millisecond)
 	return plan
 }
 
@@ -202,15 +352,17 @@ type PlanProducer interface {
 type planProducer[V fmt.Stringer] struct {
 	planner   Planner[V]
 	generator PlanGenerator[V]
+	progress  *progress
 	wg        *sync.WaitGroup
 	ctx       context.Context
 	output    chan<- PlanEntry[V]
 }
 
-func NewPlanProducer[V fmt.Stringer](cfg PlannerConfig, wg *sync.WaitGroup, ctx context.Context, generator PlanGenerator[V], output chan<- PlanEntry[V]) (PlanProducer, error) {
+func NewPlanProducer[V fmt.Stringer](planner Planner[V], progress *progress, wg *sync.WaitGroup, ctx context.Context, generator PlanGenerator[V], output chan<- PlanEntry[V]) (PlanProducer, error) {
 	return &planProducer[V]{
-		planner:   NewPlanner[V](cfg),
+		planner:   planner,
 		generator: generator,
+		progress:  progress,
 		wg:        wg,
 		ctx:       ctx,
 		output:    output,
@@ -219,22 +371,32 @@ func NewPlanProducer[V fmt.Stringer](cfg PlannerConfig, wg *sync.WaitGroup, ctx
 
 func (p *planProducer[V]) Run() {
 	defer close(p.output)
-	for _, plan := range p.planner.Plan(p.generator.Generate) {
+	cancelled := false
+	p.planner.Plan(p.generator.Generate, func(plan []PlanEntry[V]) bool {
+		samples, runtime := p.progress.measure()
+		p.progress.addBlock(len(plan))
 		p.wg.Add(len(plan))
 		for _, e := range plan {
 			select {
 			case <-p.ctx.Done():
 				klog.Error("Cancelling producer")
-				return
+				cancelled = true
+				return false
 			case p.output <- e:
 			}
 		}
 
 		if !p.wait() {
-			return
+			cancelled = true
+			return false
 		}
+		doneSamples, doneRuntime := p.progress.measure()
+		p.planner.Observe(plan, doneSamples-samples, doneRuntime-runtime)
+		return true
+	})
+	if !cancelled {
+		klog.V(0).Infof("Stopping producer")
 	}
-	klog.V(0).Infof("Stopping producer")
 }
 
 func (p *planProducer[V]) wait() bool {
@@ -262,29 +424,37 @@ type PlanConsumer interface {
 
 type planConsumer[V fmt.Stringer] struct {
 	name      string
+	config    PlannerConfig
 	l         PlanLogger[V]
 	e         PlanExecutor[V]
+	progress  *progress
+	consumer  *consumerProgress
 	cg        *sync.WaitGroup
 	wg        *sync.WaitGroup
 	ctx       context.Context
 	errorChan chan<- error
 	input     <-chan PlanEntry[V]
 	s         common.Canceller
+	retries   *retryAppender
 	stopOnce  sync.Once
 }
 
-func NewPlanConsumer[V fmt.Stringer](name string, cg *sync.WaitGroup, wg *sync.WaitGroup, ctx context.Context, errorChan chan<- error, input <-chan PlanEntry[V], s common.Canceller, e PlanExecutor[V]) (PlanConsumer, error) {
+func NewPlanConsumer[V fmt.Stringer](name string, config PlannerConfig, progress *progress, consumer *consumerProgress, cg *sync.WaitGroup, wg *sync.WaitGroup, ctx context.Context, errorChan chan<- error, input <-chan PlanEntry[V], s common.Canceller, e PlanExecutor[V], retries *retryAppender) (PlanConsumer, error) {
 	logger := &planLogger[V]{}
 	return &planConsumer[V]{
 		name:      name,
+		config:    config,
 		l:         logger,
 		e:         e,
+		progress:  progress,
+		consumer:  consumer,
 		cg:        cg,
 		wg:        wg,
 		ctx:       ctx,
 		errorChan: errorChan,
 		input:     input,
 		s:         s,
+		retries:   retries,
 	}, nil
 }
 
@@ -305,23 +475,74 @@ func (p *planConsumer[V]) Run() {
 				})
 				return
 			}
-			p.l.PrintMessage(fmt.Sprintf("Running %v", plan))
-			err := p.e.Execute(p.ctx, p.l, plan)
+			if !p.progress.bar || klog.V(1).Enabled() {
+				p.l.PrintMessage(fmt.Sprintf("Running %v", plan))
+			}
+			start := time.Now()
+			attempts, err := p.execute(plan)
 			if err != nil {
-				p.l.PrintExecutePlanError(plan, "could write data", err)
-				p.stopOnce.Do(func() {
-					p.l.PrintMessage("Cancelling consumer")
-					p.s.Cancel()
-					p.cg.Done()
-				})
-				return
+				if p.ctx.Err() != nil {
+					// The entry failed because the plan was cancelled, so it isn't reported.
+					p.stopOnce.Do(func() {
+						p.l.PrintMessage("Cancelling consumer")
+						p.cg.Done()
+					})
+					return
+				}
+				p.l.PrintExecutePlanError(plan, fmt.Sprintf("could not write data after %d attempts", attempts), err)
+				p.errorChan <- &entryError{
+					entry:    fmt.Sprint(plan),
+					data:     fmt.Sprint(*plan.Data()),
+					start:    plan.Start(),
+					end:      plan.End(),
+					attempts: attempts,
+					err:      err,
+				}
+				if !p.config.ContinueOnError() {
+					p.stopOnce.Do(func() {
+						p.l.PrintMessage("Cancelling consumer")
+						p.s.Cancel()
+						p.cg.Done()
+					})
+					return
+				}
+				p.progress.fail()
+				p.wg.Done()
 			} else {
+				p.progress.complete(p.consumer, time.Since(start))
 				p.wg.Done()
 			}
 		}
 	}
 }
 
+// execute executes the plan entry, and retries it with an exponential backoff when it fails.  It returns the number of
+// attempts, and the error of the last attempt.
+func (p *planConsumer[V]) execute(plan PlanEntry[V]) (int, error) {
+	var b backoff.BackOff = &backoff.StopBackOff{}
+	if p.config.Retries() > 0 {
+		// WithMaxRetries retries forever when the maximum is zero.
+		exponential := backoff.NewExponentialBackOff()
+		exponential.InitialInterval = p.config.RetryBackoff()
+		exponential.MaxElapsedTime = 0
+		b = backoff.WithMaxRetries(exponential, uint64(p.config.Retries()))
+	}
+	if p.retries != nil {
+		p.retries.start()
+	}
+	attempts := 0
+	err := backoff.RetryNotify(func() error {
+		attempts++
+		if attempts > 1 && p.retries != nil {
+			p.retries.retry()
+		}
+		return p.e.Execute(p.ctx, p.l, plan)
+	}, backoff.WithContext(b, p.ctx), func(err error, next time.Duration) {
+		p.l.PrintMessage("Retrying %v in %s after attempt %d failed: %v", plan, next.Round(time.Millisecond), attempts, err)
+	})
+	return attempts, err
+}
+
 type PlanLogger[V fmt.Stringer] interface {
 	PrintMessage(format string, args ...interface{})
 	PrintExecutePlanError(plan PlanEntry[V], msg string, err error)
@@ -346,9 +567,10 @@ type PlannedBlockWriter interface {
 	Run() error
 }
 
-func NewPlannedBlockWriter[V fmt.Stringer](config PlannerConfig, generator PlanGenerator[V], executorCreator PlanExecutorCreator[V]) PlannedBlockWriter {
+func NewPlannedBlockWriter[V fmt.Stringer](config PlannerConfig, output Output, generator PlanGenerator[V], executorCreator PlanExecutorCreator[V]) PlannedBlockWriter {
 	return &plannedBlockWriter[V]{
 		config:          config,
+		output:          output,
 		generator:       generator,
 		executorCreator: executorCreator,
 	}
@@ -356,34 +578,21 @@ func NewPlannedBlockWriter[V fmt.Stringer](config PlannerConfig, generator PlanG
 
 type plannedBlockWriter[V fmt.Stringer] struct {
 	config          PlannerConfig
+	output          Output
 	generator       PlanGenerator[V]
 	executorCreator PlanExecutorCreator[V]
 }
 
 func (p *plannedBlockWriter[V]) Run() error {
-	startInMs := p.config.StartTime().Unix() * int64(time.Second/time.Millisecond)
-	endInMs := p.config.EndTime().Unix() * int64(time.Second/time.Millisecond)
-	blockDuration := database.GetCompatibleBlockDuration(endInMs - startInMs)
+	defer func(output Output) {
+		_ = output.Close()
+	}(p.output)
 
-	tempDirectory, err := database.NewTempDirectory(p.config.OutputDirectory(), tmpGenerateDirSuffix)
+	appendManager, err := p.output.AppendManager()
 	if err != nil {
 		return err
 	}
 
-	db, err := database.NewDatabase(tempDirectory, blockDuration, database.DefaultRetention,
-		context.Background())
-	if err != nil {
-		return errors.Wrap(err, "failed to open db")
-	}
-	defer func(db database.Database) {
-		_ = db.Close()
-	}(db)
-
-	appendManager, err := db.AppendManager()
-	if err != nil {
-		return errors.Wrap(err, "failed to get appender")
-	}
-
 	var wg sync.WaitGroup
 	var cg sync.WaitGroup
 	ctx, cancel := context.WithCancel(context.Background())
@@ -391,14 +600,22 @@ func (p *plannedBlockWriter[V]) Run() error {
 	errChan := make(chan error)
 	inputChan := make(chan PlanEntry[V])
 
-	producer, err := NewPlanProducer[V](p.config, &wg, ctx, p.generator, inputChan)
+	planner := NewPlanner[V](p.config)
+	progress := newProgress(planner.Blocks(), appendManager.Stats())
+	reporter := newProgressReporter(progress, os.Stderr)
+	producer, err := NewPlanProducer[V](planner, progress, &wg, ctx, p.generator, inputChan)
 	if err != nil {
 		cancel()
 		return errors.Wrap(err, "failed to start producer")
 	}
 	go producer.Run()
 
-	for i := uint8(0); i < p.config.Parallelism(); i++ {
+	parallelism := p.config.Parallelism()
+	if p.output.Sequential() && parallelism > 1 {
+		klog.V(0).Infof("Using 1 consumer instead of %d, as the output requires samples in time order", parallelism)
+		parallelism = 1
+	}
+	for i := uint8(0); i < parallelism; i++ {
 		cg.Add(1)
 
 		appender, errA := appendManager.NewAppender()
@@ -408,12 +625,19 @@ func (p *plannedBlockWriter[V]) Run() error {
 		}
 
 		name := fmt.Sprintf("planConsumer%d", i)
+		consumerProgress := progress.addConsumer(name)
+		appender = &progressAppender{Appender: appender, consumer: consumerProgress}
+		var retries *retryAppender
+		if p.config.Retries() > 0 {
+			retries = newRetryAppender(appender)
+			appender = retries
+		}
 		executor, errE := p.executorCreator.Create(name, appender)
 		if errE != nil {
 			cancel()
 			return errors.Wrap(errE, "failed to start consumers")
 		}
-		consumer, errC := NewPlanConsumer(name, &cg, &wg, ctx, errChan, inputChan, s, executor)
+		consumer, errC := NewPlanConsumer(name, p.config, progress, consumerProgress, &cg, &wg, ctx, errChan, inputChan, s, executor, retries)
 		if errC != nil {
 			cancel()
 			return errors.Wrap(errC, "failed to start consumers")
@@ -429,18 +653,45 @@ func (p *plannedBlockWriter[V]) Run() error {
 		}
 	}()
 
+	report := &failureReport{}
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for e := range errChan {
+			report.add(e)
+		}
+	}()
+
+	reporter.start()
 	cg.Wait()
 	cancel()
+	reporter.stop()
+	close(errChan)
+	<-collected
 
-	err = appendManager.Close()
-	if err != nil {
+	if err = report.write(p.config.FailedEntriesFile()); err != nil {
 		return err
 	}
+	if !p.config.ContinueOnError() {
+		if err = report.err(); err != nil {
+			return errors.Wrap(err, "failed to write blocks")
+		}
+	}
 
-	err = db.Compact()
-	if err != nil {
+	if err = p.output.Commit(); err != nil {
 		return err
 	}
-
-	return database.MoveBlocks(tempDirectory, p.config.OutputDirectory())
+	appendStats := appendManager.Stats()
+	appendStats.Log()
+	if err = report.err(); err != nil {
+		report.log()
+		if p.config.FailedEntriesFile() != "" {
+			return errors.Wrap(err, "failed to write blocks, see %s", p.config.FailedEntriesFile())
+		}
+		return errors.Wrap(err, "failed to write blocks")
+	}
+	if p.config.Strict() && appendStats.Dropped() > 0 {
+		return errors.New("dropped %d samples", appendStats.Dropped())
+	}
+	return nil
 }
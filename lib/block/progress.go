@@ -0,0 +1,288 @@
+package block
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/mattn/go-isatty"
+	"github.com/prometheus/prometheus/promql"
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressBarInterval = 500 * time.Millisecond
+	progressLogInterval = 10 * time.Second
+	progressBarWidth    = 30
+)
+
+// progress tracks the plan entries completed by the consumers of a planned block writer, and the samples and bytes
+// they wrote.  The total number of entries is only known for the blocks planned so far, so it is estimated from the
+// entries per block until the last block is planned.
+type progress struct {
+	start        time.Time
+	bar          bool
+	stats        *database.AppendStats
+	mtx          sync.Mutex
+	totalBlocks  int64
+	blocks       int64
+	planned      int64
+	blockPlanned int64
+	completed    int64
+	failed       int64
+	consumers    []*consumerProgress
+}
+
+func newProgress(totalBlocks int, stats *database.AppendStats) *progress {
+	return &progress{
+		start:       time.Now(),
+		stats:       stats,
+		totalBlocks: int64(totalBlocks),
+	}
+}
+
+// consumerProgress tracks the plan entries executed by a consumer, and the samples it added.
+type consumerProgress struct {
+	name    string
+	entries int64
+	samples uint64
+	runtime int64
+}
+
+func (p *progress) addConsumer(name string) *consumerProgress {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	c := &consumerProgress{name: name}
+	p.consumers = append(p.consumers, c)
+	return c
+}
+
+// addBlock records the entries planned for the next block.
+func (p *progress) addBlock(entries int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.blocks++
+	p.blockPlanned = p.planned
+	p.planned += int64(entries)
+}
+
+// complete records an entry executed by the consumer.
+func (p *progress) complete(c *consumerProgress, runtime time.Duration) {
+	atomic.AddInt64(&c.runtime, int64(runtime))
+	atomic.AddInt64(&c.entries, 1)
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.completed++
+}
+
+// fail records an entry which failed on every attempt.
+func (p *progress) fail() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.failed++
+}
+
+// measure returns the samples added and the time spent executing entries by all the consumers.
+func (p *progress) measure() (uint64, time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var samples uint64
+	var runtime int64
+	for _, c := range p.consumers {
+		samples += atomic.LoadUint64(&c.samples)
+		runtime += atomic.LoadInt64(&c.runtime)
+	}
+	return samples, time.Duration(runtime)
+}
+
+func (p *progress) snapshot() progressSnapshot {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	s := progressSnapshot{
+		elapsed:   time.Since(p.start),
+		completed: p.completed,
+		failed:    p.failed,
+		total:     p.planned,
+		samples:   p.stats.Appended(),
+		bytes:     p.stats.Written(),
+	}
+	if p.blocks > 0 && p.blocks < p.totalBlocks {
+		s.total += (p.totalBlocks - p.blocks) * p.planned / p.blocks
+	}
+	if p.totalBlocks > 0 && p.blocks > 0 {
+		// Blocks are executed one at a time, so the blocks planned before the current one are complete.
+		blockFraction := 1.0
+		if blockEntries := p.planned - p.blockPlanned; blockEntries > 0 {
+			blockFraction = float64(p.completed+p.failed-p.blockPlanned) / float64(blockEntries)
+		}
+		s.fraction = (float64(p.blocks-1) + blockFraction) / float64(p.totalBlocks)
+		if s.fraction > 1 {
+			s.fraction = 1
+		}
+	}
+	for _, c := range p.consumers {
+		s.consumers = append(s.consumers, consumerSnapshot{
+			name:    c.name,
+			entries: atomic.LoadInt64(&c.entries),
+			samples: atomic.LoadUint64(&c.samples),
+			runtime: time.Duration(atomic.LoadInt64(&c.runtime)),
+		})
+	}
+	return s
+}
+
+type progressSnapshot struct {
+	elapsed   time.Duration
+	fraction  float64
+	completed int64
+	failed    int64
+	total     int64
+	samples   uint64
+	bytes     uint64
+	consumers []consumerSnapshot
+}
+
+func (s progressSnapshot) eta() time.Duration {
+	if s.fraction <= 0 || s.fraction >= 1 {
+		return 0
+	}
+	return time.Duration(float64(s.elapsed) * (1 - s.fraction) / s.fraction).Round(time.Second)
+}
+
+func (s progressSnapshot) samplesPerSecond() float64 {
+	return perSecond(s.samples, s.elapsed)
+}
+
+func (s progressSnapshot) bar() string {
+	filled := int(s.fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	eta := "--"
+	if s.fraction > 0 {
+		eta = s.eta().String()
+	}
+	failed := ""
+	if s.failed > 0 {
+		failed = fmt.Sprintf(" (%d failed)", s.failed)
+	}
+	return fmt.Sprintf("[%s] %5.1f%%  %d/~%d entries%s  %s samples (%s/s)  %s  ETA %s", bar, s.fraction*100,
+		s.completed, s.total, failed, humanize.SIWithDigits(float64(s.samples), 1, ""),
+		humanize.SIWithDigits(s.samplesPerSecond(), 1, ""), humanize.IBytes(s.bytes), eta)
+}
+
+func (s progressSnapshot) log(msg string) {
+	klog.V(0).InfoS(msg, "percent", fmt.Sprintf("%.1f", s.fraction*100), "entries", s.completed,
+		"failedEntries", s.failed, "estimatedEntries", s.total, "samples", s.samples, "samplesPerSecond", int64(s.samplesPerSecond()),
+		"bytes", s.bytes, "elapsed", s.elapsed.Round(time.Second).String(), "eta", s.eta().String())
+	for _, c := range s.consumers {
+		klog.V(1).InfoS("Consumer progress", "consumer", c.name, "entries", c.entries, "samples", c.samples,
+			"samplesPerSecond", int64(perSecond(c.samples, c.runtime)), "busy", c.runtime.Round(time.Second).String())
+	}
+}
+
+type consumerSnapshot struct {
+	name    string
+	entries int64
+	samples uint64
+	runtime time.Duration
+}
+
+func perSecond(value uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(value) / d.Seconds()
+}
+
+// progressReporter periodically renders the progress as a bar when the output is a terminal, and as log lines
+// otherwise.
+type progressReporter struct {
+	progress *progress
+	out      io.Writer
+	terminal bool
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// newProgressReporter creates a reporter for the progress.  The entries run by the consumers are only logged at higher
+// verbosity when the progress is rendered as a bar.
+func newProgressReporter(p *progress, out *os.File) *progressReporter {
+	terminal := isatty.IsTerminal(out.Fd()) || isatty.IsCygwinTerminal(out.Fd())
+	p.bar = terminal
+	return &progressReporter{
+		progress: p,
+		out:      out,
+		terminal: terminal,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func (r *progressReporter) start() {
+	go r.run()
+}
+
+// stop renders the final progress, and waits for the reporter to finish.
+func (r *progressReporter) stop() {
+	close(r.done)
+	<-r.stopped
+}
+
+func (r *progressReporter) run() {
+	defer close(r.stopped)
+	interval := progressLogInterval
+	if r.terminal {
+		interval = progressBarInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			r.render(true)
+			return
+		case <-ticker.C:
+			r.render(false)
+		}
+	}
+}
+
+func (r *progressReporter) render(final bool) {
+	s := r.progress.snapshot()
+	if r.terminal {
+		_, _ = fmt.Fprintf(r.out, "\r\033[K%s", s.bar())
+		if final {
+			_, _ = fmt.Fprintln(r.out)
+		}
+	}
+	if final {
+		if s.fraction < 1 {
+			s.log("Stopped")
+		} else {
+			s.log("Completed")
+		}
+	} else if !r.terminal {
+		s.log("Progress")
+	}
+}
+
+// progressAppender counts the samples added by a consumer.
+type progressAppender struct {
+	database.Appender
+	consumer *consumerProgress
+}
+
+func (a *progressAppender) Add(sample *promql.Sample) error {
+	if err := a.Appender.Add(sample); err != nil {
+		return err
+	}
+	atomic.AddUint64(&a.consumer.samples, 1)
+	return nil
+}
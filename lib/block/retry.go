@@ -0,0 +1,47 @@
+package block
+
+import (
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// retryAppender skips the samples which the failed attempts of a plan entry already appended, so retrying the entry
+// doesn't count them as dropped, or send them to a remote write endpoint again.
+type retryAppender struct {
+	database.Appender
+	latest   map[uint64]int64
+	retrying bool
+}
+
+func newRetryAppender(appender database.Appender) *retryAppender {
+	return &retryAppender{
+		Appender: appender,
+		latest:   map[uint64]int64{},
+	}
+}
+
+// start forgets the samples appended for the previous plan entry.
+func (a *retryAppender) start() {
+	a.latest = map[uint64]int64{}
+	a.retrying = false
+}
+
+// retry skips the samples of each series up to the latest one appended by the previous attempts.
+func (a *retryAppender) retry() {
+	a.retrying = true
+}
+
+func (a *retryAppender) Add(sample *promql.Sample) error {
+	hash := sample.Metric.Hash()
+	last, ok := a.latest[hash]
+	if ok && a.retrying && sample.T <= last {
+		return nil
+	}
+	if err := a.Appender.Add(sample); err != nil {
+		return err
+	}
+	if !ok || sample.T > last {
+		a.latest[hash] = sample.T
+	}
+	return nil
+}
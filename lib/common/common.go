@@ -45,6 +45,17 @@ func LabelProtosToLabels(labelPairs []prompb.Label) labels.Labels {
 	return result
 }
 
+func LabelsToLabelProtos(ls labels.Labels) []prompb.Label {
+	result := make([]prompb.Label, 0, len(ls))
+	for _, l := range ls {
+		result = append(result, prompb.Label{
+			Name:  l.Name,
+			Value: l.Value,
+		})
+	}
+	return result
+}
+
 func DownsampleMatrix(matrix promql.Matrix, maxSamples int, avg bool) promql.Matrix {
 	var newMatrix promql.Matrix
 	downsampler := downsample.NewLttbDownsampler()
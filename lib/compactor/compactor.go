@@ -6,6 +6,15 @@ import (
 	"github.com/kadaan/promutil/lib/command"
 	"github.com/kadaan/promutil/lib/database"
 	"github.com/kadaan/promutil/lib/errors"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	walDirectory        = "wal"
+	tmpCompactDirSuffix = ".tmp-for-compact"
 )
 
 func NewCompactor() command.Task[config.CompactConfig] {
@@ -16,18 +25,71 @@ type compactor struct {
 }
 
 func (t *compactor) Run(c *config.CompactConfig) error {
-	db, err := database.NewDatabase(c.Directory, database.DefaultBlockDuration, database.DefaultRetention,
-		context.Background())
+	minBlockDuration := c.MinBlockDuration.Milliseconds()
+	if _, err := os.Stat(filepath.Join(c.Directory, walDirectory)); err == nil {
+		if err = compactHead(c.Directory); err != nil {
+			return err
+		}
+	}
+
+	compactions, err := database.CompactBlocks(context.Background(), c.Directory, database.CompactOptions{
+		MinBlockDuration: minBlockDuration,
+		MaxBlockDuration: c.MaxBlockDuration.Milliseconds(),
+		MaxBlockSize:     c.MaxBlockSize,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to compact blocks")
+	}
+	sources := 0
+	for _, compaction := range compactions {
+		sources += len(compaction.Sources)
+	}
+	klog.V(0).Infof("Performed %d compactions merging %d blocks with ranges %s", len(compactions), sources, formatRanges(database.BlockRanges(minBlockDuration, c.MaxBlockDuration.Milliseconds())))
+	return nil
+}
+
+// compactHead replays the WAL of a Prometheus data directory, and moves the samples which are newer than its blocks into
+// a new block, so they are compacted with the other blocks.  The WAL is left in place.
+func compactHead(dir string) error {
+	tmpDir, err := database.NewTempDirectory(dir, tmpCompactDirSuffix)
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer func(tmpDir string) {
+		_ = os.RemoveAll(tmpDir)
+	}(tmpDir)
+	if err = os.MkdirAll(tmpDir, 0o777); err != nil {
+		return errors.Wrap(err, "failed to create directory: %s", tmpDir)
+	}
+
+	db, err := database.NewReadOnlyDatabase(dir)
 	if err != nil {
 		return errors.Wrap(err, "failed to open db")
 	}
-	defer func(db database.Database) {
+	if err = db.FlushWAL(tmpDir); err != nil {
 		_ = db.Close()
-	}(db)
+		return err
+	}
+	if err = db.Close(); err != nil {
+		return errors.Wrap(err, "failed to close db")
+	}
 
-	err = db.Compact()
+	blockDirs, err := database.BlockDirectories(tmpDir)
 	if err != nil {
-		return errors.Wrap(err, "failed to compact")
+		return err
 	}
-	return nil
+	if len(blockDirs) == 0 {
+		klog.V(0).Infof("The WAL has no samples newer than the blocks")
+		return nil
+	}
+	klog.V(0).Infof("Persisted the WAL samples newer than the blocks as block %s", filepath.Base(blockDirs[0]))
+	return errors.Wrap(database.MoveBlocks(tmpDir, dir), "failed to move the WAL block")
+}
+
+func formatRanges(ranges []int64) []time.Duration {
+	var durations []time.Duration
+	for _, r := range ranges {
+		durations = append(durations, time.Duration(r)*time.Millisecond)
+	}
+	return durations
 }
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"github.com/go-kit/kit/log"
 	"github.com/kadaan/promutil/lib/errors"
+	"github.com/oklog/ulid"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
+	"math"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
@@ -20,6 +22,7 @@ const (
 
 type AppendManager interface {
 	NewAppender() (Appender, error)
+	Stats() *AppendStats
 	Close() error
 }
 
@@ -31,16 +34,20 @@ type appendManager struct {
 	dir           string
 	blockDuration int64
 	appenders     []Appender
+	stats         *AppendStats
+	memory        *memoryBudget
 	stopped       bool
 	resetFunc     func()
 }
 
-func newAppendManager(ctx context.Context, mtx *sync.RWMutex, dir string, blockDuration int64, resetFunc func()) (AppendManager, error) {
+func newAppendManager(ctx context.Context, mtx *sync.RWMutex, dir string, blockDuration int64, maxMemory int64, resetFunc func()) (AppendManager, error) {
 	return &appendManager{
 		context:       ctx,
 		mtx:           mtx,
 		dir:           dir,
 		blockDuration: blockDuration,
+		stats:         NewAppendStats(),
+		memory:        newMemoryBudget(mtx, maxMemory),
 		resetFunc:     resetFunc,
 	}, nil
 }
@@ -57,21 +64,27 @@ func (a *appendManager) NewAppender() (Appender, error) {
 	appender := &safeAppender{
 		context:            a.context,
 		mtx:                a.mtx,
-		maxSamplesInMemory: 15000,
 		destDir:            a.dir,
 		dir:                appenderDir,
 		blockStart:         -1,
 		blockDuration:      a.blockDuration,
 		blockFlushDuration: blockFlushDuration,
+		stats:              a.stats,
+		memory:             a.memory,
 	}
 	err := appender.newBlockWriter(appenderDir, a.blockDuration)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create block writer")
 	}
 	a.appenders = append(a.appenders, appender)
+	a.memory.register(appender)
 	return appender, nil
 }
 
+func (a *appendManager) Stats() *AppendStats {
+	return a.stats
+}
+
 func (a *appendManager) Close() error {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
@@ -81,7 +94,7 @@ func (a *appendManager) Close() error {
 	a.stopped = true
 	var errs []error
 	for _, appender := range a.appenders {
-		err := appender.close()
+		err := appender.Close()
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -89,19 +102,19 @@ func (a *appendManager) Close() error {
 	if len(errs) > 0 {
 		return errors.NewMulti(errs, "failed to close appenders")
 	}
+	a.memory.log()
 	a.resetFunc()
 	return nil
 }
 
 type Appender interface {
 	Add(sample *promql.Sample) error
-	close() error
+	Close() error
 }
 
 type safeAppender struct {
 	context            context.Context
 	mtx                *sync.RWMutex
-	maxSamplesInMemory uint64
 	currentSampleCount uint64
 	blockWriter        *tsdb.BlockWriter
 	destDir            string
@@ -110,6 +123,11 @@ type safeAppender struct {
 	blockDuration      int64
 	blockFlushDuration int64
 	appender           *storage.Appender
+	latest             map[uint64]promql.Point
+	stats              *AppendStats
+	memory             *memoryBudget
+	memoryUsage        int64
+	pendingSamples     int64
 	stopped            bool
 }
 
@@ -117,7 +135,6 @@ func (a *safeAppender) Add(sample *promql.Sample) error {
 	if a.stopped {
 		return errors.New("cannot append to a closed appender")
 	}
-	var err error
 	a.mtx.RLock()
 	if a.blockStart < 0 {
 		a.blockStart = sample.T
@@ -130,85 +147,139 @@ func (a *safeAppender) Add(sample *promql.Sample) error {
 			return errors.Wrap(errF, "failed to flush")
 		}
 		a.blockStart = a.blockStart + a.blockFlushDuration
-		if err = a.append(sample); err != nil {
-			defer a.mtx.RUnlock()
+		if err := a.append(sample); err != nil {
 			return errors.Wrap(err, "failed to append")
 		}
+		atomic.AddUint64(&a.currentSampleCount, 1)
 		return nil
 	}
 
-	if err = a.append(sample); err != nil {
-		defer a.mtx.RUnlock()
+	if err := a.append(sample); err != nil {
+		a.mtx.RUnlock()
 		return errors.Wrap(err, "failed to append")
 	}
-	if atomic.AddUint64(&a.currentSampleCount, 1) >= a.maxSamplesInMemory {
+	if atomic.AddUint64(&a.currentSampleCount, 1) >= a.memory.samplesPerCommit {
 		a.mtx.RUnlock()
 		a.mtx.Lock()
-		defer a.mtx.Unlock()
-		if atomic.LoadUint64(&a.currentSampleCount) >= a.maxSamplesInMemory {
-			if err = (*a.appender).Commit(); err != nil {
+		if atomic.LoadUint64(&a.currentSampleCount) >= a.memory.samplesPerCommit {
+			if err := a.commit(); err != nil {
+				a.mtx.Unlock()
 				return err
 			}
-			appender := a.blockWriter.Appender(a.context)
-			a.appender = &appender
-			atomic.StoreUint64(&a.currentSampleCount, 0)
 		}
+		a.mtx.Unlock()
 	} else {
 		a.mtx.RUnlock()
 	}
+	if a.memory.exceeded() {
+		return errors.Wrap(a.memory.reclaim(), "failed to reclaim memory")
+	}
 	return nil
 }
 
+// append appends the sample, counting the samples which are dropped instead.  The appender of the block writer only
+// detects out of order and duplicate samples against committed samples, and silently drops those it detects on
+// commit, so the latest sample of each series is tracked here.
 func (a *safeAppender) append(sample *promql.Sample) error {
+	hash := sample.Metric.Hash()
+	if last, ok := a.latest[hash]; ok && sample.T <= last.T {
+		if sample.T < last.T {
+			a.stats.AddDropped(OutOfOrderDrop, sample.Metric, sample.T)
+		} else if math.Float64bits(sample.V) != math.Float64bits(last.V) {
+			a.stats.AddDropped(DuplicateDrop, sample.Metric, sample.T)
+		}
+		return nil
+	}
+	_, seen := a.latest[hash]
 	_, err := (*a.appender).Append(0, sample.Metric, sample.T, sample.V)
-	if err != nil {
-		switch err.Error() {
-		case storage.ErrOutOfOrderSample.Error():
-		case storage.ErrOutOfBounds.Error():
-		case storage.ErrDuplicateSampleForTimestamp.Error():
-			return nil
-		default:
-			return errors.Wrap(err, "failed to append")
+	if err == nil {
+		delta := int64(estimatedPendingSampleBytes)
+		if !seen {
+			delta += estimatedSeriesMemory(sample.Metric)
 		}
+		a.latest[hash] = sample.Point
+		a.pendingSamples++
+		a.addMemoryUsage(delta)
+		a.stats.AddAppended()
+		return nil
+	}
+	switch err.Error() {
+	case storage.ErrOutOfOrderSample.Error():
+		a.stats.AddDropped(OutOfOrderDrop, sample.Metric, sample.T)
+	case storage.ErrOutOfBounds.Error():
+		a.stats.AddDropped(OutOfBoundsDrop, sample.Metric, sample.T)
+	case storage.ErrDuplicateSampleForTimestamp.Error():
+		a.stats.AddDropped(DuplicateDrop, sample.Metric, sample.T)
+	default:
+		return errors.Wrap(err, "failed to append")
 	}
 	return nil
 }
 
+func (a *safeAppender) commit() error {
+	if err := (*a.appender).Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit")
+	}
+	appender := a.blockWriter.Appender(a.context)
+	a.appender = &appender
+	atomic.StoreUint64(&a.currentSampleCount, 0)
+	a.addMemoryUsage(-a.pendingSamples * (estimatedPendingSampleBytes - estimatedSampleBytes))
+	a.pendingSamples = 0
+	return nil
+}
+
+// flush writes the samples of the appender to a block, and starts a new block writer.  It must be called with the
+// lock held.
 func (a *safeAppender) flush() error {
 	if a.stopped {
 		return errors.New("cannot flush a closed appender")
 	}
+	if err := a.flushBlockWriter(); err != nil {
+		return err
+	}
+	err := a.newBlockWriter(a.dir, a.blockDuration)
+	if err != nil {
+		return errors.Wrap(err, "failed to create new block writer")
+	}
+	return nil
+}
+
+func (a *safeAppender) flushBlockWriter() error {
 	if atomic.LoadUint64(&a.currentSampleCount) > 0 {
 		if err := (*a.appender).Commit(); err != nil {
 			return errors.Wrap(err, "failed to commit")
 		}
 		atomic.StoreUint64(&a.currentSampleCount, 0)
 	}
-	if _, err := a.blockWriter.Flush(a.context); err != nil {
+	id, err := a.blockWriter.Flush(a.context)
+	if err != nil {
 		return errors.Wrap(err, "failed to flush block writer")
 	}
-	err := a.newBlockWriter(a.dir, a.blockDuration)
-	if err != nil {
-		return errors.Wrap(err, "failed to create new block writer")
+	if id != (ulid.ULID{}) {
+		if size, errS := dirSize(filepath.Join(a.dir, id.String())); errS == nil {
+			a.stats.AddWritten(uint64(size))
+		}
 	}
-	appender := a.blockWriter.Appender(a.context)
-	a.appender = &appender
+	if err := a.blockWriter.Close(); err != nil {
+		return errors.Wrap(err, "failed to close block writer")
+	}
+	a.addMemoryUsage(-a.memoryUsage)
+	a.pendingSamples = 0
 	return nil
 }
 
-func (a *safeAppender) close() error {
+func (a *safeAppender) addMemoryUsage(delta int64) {
+	a.memoryUsage += delta
+	a.memory.add(delta)
+}
+
+func (a *safeAppender) Close() error {
 	if a.stopped {
 		return nil
 	}
 	a.stopped = true
-	if atomic.LoadUint64(&a.currentSampleCount) > 0 {
-		if err := (*a.appender).Commit(); err != nil {
-			return errors.Wrap(err, "failed to commit")
-		}
-		atomic.StoreUint64(&a.currentSampleCount, 0)
-	}
-	if _, err := a.blockWriter.Flush(a.context); err != nil {
-		return errors.Wrap(err, "failed to flush block writer")
+	if err := a.flushBlockWriter(); err != nil {
+		return err
 	}
 	a.appender = nil
 
@@ -221,6 +292,7 @@ func (a *safeAppender) newBlockWriter(dir string, blockDuration int64) error {
 		return errors.Wrap(err, "failed to create block writer")
 	}
 	a.blockWriter = blockWriter
+	a.latest = map[uint64]promql.Point{}
 	appender := a.blockWriter.Appender(a.context)
 	a.appender = &appender
 	return nil
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/go-kit/kit/log"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CompactOptions configures the leveled compaction of the blocks in a directory.
+type CompactOptions struct {
+	MinBlockDuration int64
+	MaxBlockDuration int64
+	MaxBlockSize     int64
+}
+
+// Compaction describes the blocks merged by a single compaction.
+type Compaction struct {
+	Sources  []ulid.ULID
+	Result   ulid.ULID
+	Vertical bool
+	MinTime  int64
+	MaxTime  int64
+	Size     int64
+}
+
+type blockMeta struct {
+	dir  string
+	meta tsdb.BlockMeta
+	size int64
+}
+
+// CompactBlocks compacts the blocks in dir into blocks of exponentially increasing ranges, from minBlockDuration up
+// to maxBlockDuration.  Overlapping blocks are vertically compacted first.  Unlike the compaction of a running
+// Prometheus, the most recent block is compacted as well.  Blocks are only merged if the merged size doesn't exceed
+// the maximum block size, when one is given.
+func CompactBlocks(ctx context.Context, dir string, options CompactOptions) ([]Compaction, error) {
+	ranges := BlockRanges(options.MinBlockDuration, options.MaxBlockDuration)
+	return compactBlocks(ctx, dir, ranges, func(blocks []blockMeta) ([]blockMeta, bool) {
+		return planCompaction(blocks, ranges, options.MaxBlockSize)
+	})
+}
+
+// CompactOverlappingBlocks vertically compacts the overlapping blocks in dir, leaving the other blocks untouched.
+func CompactOverlappingBlocks(ctx context.Context, dir string) ([]Compaction, error) {
+	return compactBlocks(ctx, dir, []int64{DefaultBlockDuration}, func(blocks []blockMeta) ([]blockMeta, bool) {
+		return selectOverlappingBlocks(blocks), true
+	})
+}
+
+func compactBlocks(ctx context.Context, dir string, ranges []int64, planner func([]blockMeta) ([]blockMeta, bool)) ([]Compaction, error) {
+	compactor, err := tsdb.NewLeveledCompactor(ctx, prometheus.NewRegistry(), log.NewNopLogger(), ranges, chunkenc.NewPool(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create compactor")
+	}
+
+	var compactions []Compaction
+	for {
+		if err = ctx.Err(); err != nil {
+			return compactions, err
+		}
+		blocks, errB := readBlockMetas(dir)
+		if errB != nil {
+			return compactions, errB
+		}
+		plan, vertical := planner(blocks)
+		if len(plan) == 0 {
+			return compactions, nil
+		}
+
+		var dirs []string
+		compaction := Compaction{
+			Vertical: vertical,
+			MinTime:  plan[0].meta.MinTime,
+			MaxTime:  plan[0].meta.MaxTime,
+		}
+		for _, b := range plan {
+			dirs = append(dirs, b.dir)
+			compaction.Sources = append(compaction.Sources, b.meta.ULID)
+			compaction.MinTime = common.MinInt64(compaction.MinTime, b.meta.MinTime)
+			compaction.MaxTime = common.MaxInt64(compaction.MaxTime, b.meta.MaxTime)
+		}
+		uid, errC := compactor.Compact(dir, dirs, nil)
+		if errC != nil {
+			return compactions, errors.Wrap(errC, "failed to compact blocks: %s", strings.Join(dirs, ", "))
+		}
+		compaction.Result = uid
+		if uid != (ulid.ULID{}) {
+			if size, errS := dirSize(filepath.Join(dir, uid.String())); errS == nil {
+				compaction.Size = size
+			}
+		}
+		for _, d := range dirs {
+			if errR := os.RemoveAll(d); errR != nil {
+				return compactions, errors.Wrap(errR, "failed to remove compacted block: %s", d)
+			}
+		}
+		compactions = append(compactions, compaction)
+		klog.V(0).Infof("Compacted %s", compaction)
+	}
+}
+
+// BlockRanges returns the exponential block ranges, starting at minBlockDuration, which don't exceed
+// maxBlockDuration.
+func BlockRanges(minBlockDuration int64, maxBlockDuration int64) []int64 {
+	ranges := tsdb.ExponentialBlockRanges(minBlockDuration, 10, 3)
+	for i, v := range ranges {
+		if v > maxBlockDuration {
+			return ranges[:common.MaxInt64(int64(i), 1)]
+		}
+	}
+	return ranges
+}
+
+func (c Compaction) String() string {
+	var sources []string
+	for _, s := range c.Sources {
+		sources = append(sources, s.String())
+	}
+	kind := "blocks"
+	if c.Vertical {
+		kind = "overlapping blocks"
+	}
+	result := "nothing, all samples were deleted"
+	if c.Result != (ulid.ULID{}) {
+		result = fmt.Sprintf("%s (%s)", c.Result, humanize.IBytes(uint64(c.Size)))
+	}
+	return fmt.Sprintf("%d %s from %s into %s [%s]", len(c.Sources), kind, common.FormatDateRange(c.MinTime, c.MaxTime),
+		result, strings.Join(sources, ", "))
+}
+
+func readBlockMetas(dir string) ([]blockMeta, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read directory: %s", dir)
+	}
+	var blocks []blockMeta
+	for _, f := range files {
+		if !isBlockDir(f) {
+			continue
+		}
+		blockDir := filepath.Join(dir, f.Name())
+		b, errO := tsdb.OpenBlock(log.NewNopLogger(), blockDir, nil)
+		if errO != nil {
+			return nil, errors.Wrap(errO, "failed to open block: %s", blockDir)
+		}
+		blocks = append(blocks, blockMeta{
+			dir:  blockDir,
+			meta: b.Meta(),
+			size: b.Size(),
+		})
+		if errC := b.Close(); errC != nil {
+			return nil, errors.Wrap(errC, "failed to close block: %s", blockDir)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].meta.MinTime < blocks[j].meta.MinTime
+	})
+	return blocks, nil
+}
+
+func planCompaction(blocks []blockMeta, ranges []int64, maxBlockSize int64) ([]blockMeta, bool) {
+	if overlapping := selectOverlappingBlocks(blocks); len(overlapping) > 0 {
+		return overlapping, true
+	}
+	if len(blocks) < 2 {
+		return nil, false
+	}
+	for _, tr := range ranges[1:] {
+		for _, group := range splitByRange(blocks, tr) {
+			if p := selectWithinSize(group, maxBlockSize); len(p) > 1 {
+				return p, false
+			}
+		}
+	}
+	return nil, false
+}
+
+func selectOverlappingBlocks(blocks []blockMeta) []blockMeta {
+	if len(blocks) < 2 {
+		return nil
+	}
+	var overlapping []blockMeta
+	globalMaxt := blocks[0].meta.MaxTime
+	for i, b := range blocks[1:] {
+		if b.meta.MinTime < globalMaxt {
+			if len(overlapping) == 0 {
+				overlapping = append(overlapping, blocks[i])
+			}
+			overlapping = append(overlapping, b)
+		} else if len(overlapping) > 0 {
+			break
+		}
+		if b.meta.MaxTime > globalMaxt {
+			globalMaxt = b.meta.MaxTime
+		}
+	}
+	return overlapping
+}
+
+func splitByRange(blocks []blockMeta, tr int64) [][]blockMeta {
+	var groups [][]blockMeta
+	for i := 0; i < len(blocks); {
+		var t0 int64
+		m := blocks[i].meta
+		if m.MinTime >= 0 {
+			t0 = tr * (m.MinTime / tr)
+		} else {
+			t0 = tr * ((m.MinTime - tr + 1) / tr)
+		}
+		if m.MaxTime > t0+tr {
+			i++
+			continue
+		}
+		var group []blockMeta
+		for ; i < len(blocks); i++ {
+			if blocks[i].meta.MaxTime > t0+tr {
+				break
+			}
+			group = append(group, blocks[i])
+		}
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// selectWithinSize returns the first run of consecutive blocks in the group whose total size doesn't exceed
+// maxBlockSize.
+func selectWithinSize(group []blockMeta, maxBlockSize int64) []blockMeta {
+	if maxBlockSize <= 0 {
+		return group
+	}
+	for start := 0; start < len(group)-1; start++ {
+		size := group[start].size
+		end := start + 1
+		for ; end < len(group) && size+group[end].size <= maxBlockSize; end++ {
+			size += group[end].size
+		}
+		if end-start > 1 {
+			return group[start:end]
+		}
+	}
+	return nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
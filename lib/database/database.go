@@ -38,6 +38,7 @@ type database struct {
 	dir                string
 	blockDuration      int64
 	retentionPeriod    int64
+	maxMemory          int64
 	dbOnce             sync.Once
 	dbError            error
 	db                 *tsdb.DB
@@ -49,7 +50,13 @@ type database struct {
 	queryManager       QueryManager
 }
 
+// NewTempDirectory returns a temporary directory next to dir, rather than inside it, so partially written blocks never
+// appear in dir.
 func NewTempDirectory(dir string, extension string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve directory: %s", dir)
+	}
 	uid := ulid.MustNew(ulid.Now(), rand.Reader)
 	if err := deleteOldTempDirectories(dir, extension, uid.Time()); err != nil {
 		return "", err
@@ -89,17 +96,27 @@ func MoveBlocks(sourceDir string, destDir string) error {
 	}
 	for _, f := range files {
 		if isBlockDir(f) {
-			from := filepath.Join(sourceDir, f.Name())
-			to := filepath.Join(destDir, f.Name())
-			errR := fileutil.Replace(from, to)
-			if errR != nil {
-				return errors.Wrap(errR, "failed to replace %s with %s", from, to)
+			if errM := moveBlock(filepath.Join(sourceDir, f.Name()), destDir); errM != nil {
+				return errM
 			}
 		}
 	}
 	return errors.Wrap(os.RemoveAll(sourceDir), "failed to remove: %s", sourceDir)
 }
 
+// MoveBlock moves a block into destDir, replacing the block with the same ULID if there is one.
+func MoveBlock(blockDir string, destDir string) error {
+	if err := os.MkdirAll(destDir, 0o777); err != nil {
+		return errors.Wrap(err, "failed to create directory: %s", destDir)
+	}
+	return moveBlock(blockDir, destDir)
+}
+
+func moveBlock(blockDir string, destDir string) error {
+	to := filepath.Join(destDir, filepath.Base(blockDir))
+	return errors.Wrap(fileutil.Replace(blockDir, to), "failed to replace %s with %s", blockDir, to)
+}
+
 func isBlockDir(fi fs.DirEntry) bool {
 	if !fi.IsDir() {
 		return false
@@ -108,6 +125,21 @@ func isBlockDir(fi fs.DirEntry) bool {
 	return err == nil
 }
 
+// BlockDirectories returns the directories of the blocks in dir, sorted by ULID.
+func BlockDirectories(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read directory: %s", dir)
+	}
+	var dirs []string
+	for _, f := range files {
+		if isBlockDir(f) {
+			dirs = append(dirs, filepath.Join(dir, f.Name()))
+		}
+	}
+	return dirs, nil
+}
+
 func GetCompatibleBlockDuration(maxBlockDuration int64) int64 {
 	blockDuration := DefaultBlockDuration
 	if maxBlockDuration > DefaultBlockDuration {
@@ -124,7 +156,7 @@ func GetCompatibleBlockDuration(maxBlockDuration int64) int64 {
 	return blockDuration
 }
 
-func NewDatabase(dir string, blockDuration int64, retentionPeriod int64, ctx context.Context) (Database, error) {
+func NewDatabase(dir string, blockDuration int64, retentionPeriod int64, maxMemory int64, ctx context.Context) (Database, error) {
 	mtx := new(sync.RWMutex)
 	db := &database{
 		context:           ctx,
@@ -132,6 +164,7 @@ func NewDatabase(dir string, blockDuration int64, retentionPeriod int64, ctx con
 		dir:               dir,
 		blockDuration:     blockDuration,
 		retentionPeriod:   retentionPeriod,
+		maxMemory:         maxMemory,
 		appendManagerOnce: new(sync.Once),
 		queryManagerOnce:  new(sync.Once),
 		stopped:           false,
@@ -146,7 +179,7 @@ func (d *database) AppendManager() (AppendManager, error) {
 		d.appendManagerError = nil
 	}
 	d.appendManagerOnce.Do(func() {
-		a, err := newAppendManager(d.context, d.mtx, d.dir, d.blockDuration, appendManagerResetFunc)
+		a, err := newAppendManager(d.context, d.mtx, d.dir, d.blockDuration, d.maxMemory, appendManagerResetFunc)
 		if err != nil {
 			d.appendManagerError = errors.Wrap(err, "failed to create append manager")
 		}
@@ -198,6 +231,11 @@ func (d *database) Close() error {
 			}
 		}
 	}
+	if d.db != nil {
+		if err2 := d.db.Close(); err2 != nil && err == nil {
+			err = errors.Wrap(err2, "failed to close database")
+		}
+	}
 	return err
 }
 
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+)
+
+// DeleteSeries records tombstones for the samples within mint and maxt of the series matching any of the matcher sets,
+// in each block of dir overlapping the time range.  The blocks are opened one at a time, instead of as a database, so
+// the other blocks and the WAL are never compacted or rewritten.  When cleanTombstones is set, each block with
+// tombstones is rewritten without the deleted samples, and replaced.
+func DeleteSeries(ctx context.Context, dir string, mint int64, maxt int64, matcherSets [][]*labels.Matcher, cleanTombstones bool) error {
+	blocks, err := readBlockMetas(dir)
+	if err != nil {
+		return err
+	}
+	var compactor tsdb.Compactor
+	if cleanTombstones {
+		compactor, err = tsdb.NewLeveledCompactor(ctx, prometheus.NewRegistry(), log.NewNopLogger(),
+			[]int64{DefaultBlockDuration}, chunkenc.NewPool(), nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to create compactor")
+		}
+	}
+	for _, bm := range blocks {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if bm.meta.MaxTime < mint || bm.meta.MinTime > maxt {
+			continue
+		}
+		if err = deleteBlockSeries(bm.dir, mint, maxt, matcherSets, compactor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBlockSeries records the tombstones of the block, and rewrites it without the deleted samples when a compactor
+// is given.  The rewritten block is written before the block is removed, and lists it as its parent, so a crash
+// leaves either the block or its replacement.
+func deleteBlockSeries(blockDir string, mint int64, maxt int64, matcherSets [][]*labels.Matcher, compactor tsdb.Compactor) error {
+	b, err := tsdb.OpenBlock(log.NewNopLogger(), blockDir, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to open block: %s", blockDir)
+	}
+	for _, matchers := range matcherSets {
+		if err = b.Delete(mint, maxt, matchers...); err != nil {
+			_ = b.Close()
+			return errors.Wrap(err, "failed to delete series from block: %s", blockDir)
+		}
+	}
+	if compactor == nil {
+		return errors.Wrap(b.Close(), "failed to close block: %s", blockDir)
+	}
+
+	uid, rewritten, err := b.CleanTombstones(filepath.Dir(blockDir), compactor)
+	if errC := b.Close(); errC != nil && err == nil {
+		err = errC
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to clean tombstones of block: %s", blockDir)
+	}
+	if !rewritten {
+		return nil
+	}
+	if uid != nil && *uid != (ulid.ULID{}) {
+		klog.V(1).Infof("Rewrote block %s as %s", filepath.Base(blockDir), uid)
+	} else {
+		klog.V(1).Infof("Removing block %s, as all of its samples are deleted", filepath.Base(blockDir))
+	}
+	return errors.Wrap(os.RemoveAll(blockDir), "failed to remove block: %s", blockDir)
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"github.com/dustin/go-humanize"
+	"github.com/prometheus/prometheus/model/labels"
+	"k8s.io/klog/v2"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultSamplesPerCommit     = 15000
+	minSamplesPerCommit         = 500
+	estimatedSeriesBytes        = 1024
+	estimatedLabelBytes         = 32
+	estimatedSampleBytes        = 2
+	estimatedPendingSampleBytes = 48
+	pendingMemoryShare          = 4
+)
+
+// memoryBudget bounds the estimated memory used by the block writers of the appenders of an AppendManager.  The
+// estimate is based on the series and samples in the heads of the block writers.  The number of samples per commit
+// is sized so that uncommitted samples use a fraction of the budget, and when the budget is exceeded the appenders
+// using the most memory are flushed to disk.  As flushing blocks every appender, consumers are slowed down until
+// enough memory is released.
+type memoryBudget struct {
+	mtx              *sync.RWMutex
+	limit            int64
+	used             int64
+	samplesPerCommit uint64
+	appenders        []*safeAppender
+	flushes          int
+}
+
+func newMemoryBudget(mtx *sync.RWMutex, limit int64) *memoryBudget {
+	return &memoryBudget{
+		mtx:              mtx,
+		limit:            limit,
+		samplesPerCommit: defaultSamplesPerCommit,
+	}
+}
+
+// register adds the appender to the budget, and resizes the commits.  It must be called with the lock held.
+func (b *memoryBudget) register(appender *safeAppender) {
+	b.appenders = append(b.appenders, appender)
+	if b.limit <= 0 {
+		return
+	}
+	perAppender := b.limit / int64(len(b.appenders)) / pendingMemoryShare / estimatedPendingSampleBytes
+	switch {
+	case perAppender < minSamplesPerCommit:
+		b.samplesPerCommit = minSamplesPerCommit
+	case perAppender > defaultSamplesPerCommit:
+		b.samplesPerCommit = defaultSamplesPerCommit
+	default:
+		b.samplesPerCommit = uint64(perAppender)
+	}
+}
+
+func (b *memoryBudget) add(delta int64) {
+	atomic.AddInt64(&b.used, delta)
+}
+
+func (b *memoryBudget) exceeded() bool {
+	return b.limit > 0 && atomic.LoadInt64(&b.used) > b.limit
+}
+
+// reclaim flushes the appenders using the most memory, until three quarters of the budget are free.
+func (b *memoryBudget) reclaim() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	target := b.limit / 4 * 3
+	for atomic.LoadInt64(&b.used) > target {
+		var largest *safeAppender
+		for _, a := range b.appenders {
+			if !a.stopped && a.memoryUsage > 0 && (largest == nil || a.memoryUsage > largest.memoryUsage) {
+				largest = a
+			}
+		}
+		if largest == nil {
+			return nil
+		}
+		klog.V(1).Infof("Flushing %s of %s used by the appenders to stay within the memory budget of %s",
+			humanize.IBytes(uint64(largest.memoryUsage)), humanize.IBytes(uint64(atomic.LoadInt64(&b.used))),
+			humanize.IBytes(uint64(b.limit)))
+		if err := largest.flush(); err != nil {
+			return err
+		}
+		b.flushes++
+	}
+	return nil
+}
+
+func (b *memoryBudget) log() {
+	if b.flushes > 0 {
+		klog.V(0).Infof("Flushed appenders %d times to stay within the memory budget of %s", b.flushes,
+			humanize.IBytes(uint64(b.limit)))
+	}
+}
+
+func estimatedSeriesMemory(metric labels.Labels) int64 {
+	size := int64(estimatedSeriesBytes)
+	for _, l := range metric {
+		size += int64(len(l.Name) + len(l.Value) + estimatedLabelBytes)
+	}
+	return size
+}
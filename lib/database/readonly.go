@@ -0,0 +1,88 @@
+package database
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"sync"
+)
+
+type ReadOnlyDatabase interface {
+	Blocks() []tsdb.BlockReader
+	Querier(mint int64, maxt int64) (storage.Querier, error)
+	FlushWAL(dir string) error
+	Close() error
+}
+
+type readOnlyDatabase struct {
+	mtx     sync.Mutex
+	db      *tsdb.DBReadOnly
+	blocks  []tsdb.BlockReader
+	stopped bool
+}
+
+func NewReadOnlyDatabase(dir string) (ReadOnlyDatabase, error) {
+	db, err := tsdb.OpenDBReadOnly(dir, log.NewNopLogger())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open read-only database: %s", dir)
+	}
+	blocks, err := db.Blocks()
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "failed to open blocks: %s", dir)
+	}
+	return &readOnlyDatabase{
+		db:     db,
+		blocks: blocks,
+	}, nil
+}
+
+func (d *readOnlyDatabase) Blocks() []tsdb.BlockReader {
+	return d.blocks
+}
+
+func (d *readOnlyDatabase) Querier(mint int64, maxt int64) (storage.Querier, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.stopped {
+		return nil, errors.New("cannot query a closed database")
+	}
+	var queriers []storage.Querier
+	for _, b := range d.blocks {
+		meta := b.Meta()
+		if meta.MaxTime <= mint || meta.MinTime > maxt {
+			continue
+		}
+		q, err := tsdb.NewBlockQuerier(b, mint, maxt)
+		if err != nil {
+			for _, o := range queriers {
+				_ = o.Close()
+			}
+			return nil, errors.Wrap(err, "failed to open querier for block %s", meta.ULID)
+		}
+		queriers = append(queriers, q)
+	}
+	return storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge), nil
+}
+
+// FlushWAL replays the WAL, its checkpoint and the memory-mapped head chunks, and writes the samples newer than the
+// blocks to a single block in dir.  No block is written when there are no such samples.
+func (d *readOnlyDatabase) FlushWAL(dir string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.stopped {
+		return errors.New("cannot flush the WAL of a closed database")
+	}
+	return errors.Wrap(d.db.FlushWAL(dir), "failed to flush WAL")
+}
+
+func (d *readOnlyDatabase) Close() error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.stopped {
+		return nil
+	}
+	d.stopped = true
+	return errors.Wrap(d.db.Close(), "failed to close read-only database")
+}
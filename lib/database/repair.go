@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"os"
+	"path/filepath"
+)
+
+const (
+	tmpRepairDirSuffix = ".tmp-for-repair"
+)
+
+// RebuildBlock rewrites the readable series of a block into a new block in the same directory, and then removes the
+// block.  Unreadable chunks and series with invalid labels are dropped, duplicated series are merged, samples are
+// sorted and deduplicated, and deleted samples are removed.  An empty ULID is returned when no samples remained.
+func RebuildBlock(ctx context.Context, v *BlockVerification) (ulid.ULID, error) {
+	ir, err := index.NewFileReader(filepath.Join(v.Dir, indexFilename))
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "failed to open index")
+	}
+	defer func(ir *index.Reader) {
+		_ = ir.Close()
+	}(ir)
+	cr, err := chunks.NewDirReader(filepath.Join(v.Dir, chunksDirname), nil)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "failed to open chunks")
+	}
+	defer func(cr *chunks.Reader) {
+		_ = cr.Close()
+	}(cr)
+	tr, _, err := tombstones.ReadTombstones(v.Dir)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "failed to read tombstones")
+	}
+	defer func(tr tombstones.Reader) {
+		_ = tr.Close()
+	}(tr)
+
+	groups, _, err := groupSeries(ir, repairLabels)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	parent := filepath.Dir(v.Dir)
+	tmpDir, err := NewTempDirectory(parent, tmpRepairDirSuffix)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer func(tmpDir string) {
+		_ = os.RemoveAll(tmpDir)
+	}(tmpDir)
+	uid, err := writeBlock(ctx, tmpDir, v.MaxTime-v.MinTime+1, groups, ir, cr, tr)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	if uid != (ulid.ULID{}) {
+		if err = MoveBlocks(tmpDir, parent); err != nil {
+			return ulid.ULID{}, err
+		}
+	}
+	return uid, errors.Wrap(os.RemoveAll(v.Dir), "failed to remove block: %s", v.Dir)
+}
+
+func repairLabels(lset labels.Labels) labels.Labels {
+	metric := labels.New(lset...)
+	if validateLabels(metric) != nil {
+		return nil
+	}
+	return metric
+}
+
+// RepairBlockMeta writes the meta.json of a block based on the samples it actually contains.  The compaction
+// history is kept when the existing meta.json could be read.
+func RepairBlockMeta(v *BlockVerification) (*tsdb.BlockMeta, error) {
+	meta := &tsdb.BlockMeta{
+		ULID:    v.ULID,
+		MinTime: v.MinTime,
+		MaxTime: v.MaxTime + 1,
+		Compaction: tsdb.BlockMetaCompaction{
+			Level:   1,
+			Sources: []ulid.ULID{v.ULID},
+		},
+	}
+	if v.Meta != nil {
+		*meta = *v.Meta
+		meta.MinTime = common.MinInt64(v.Meta.MinTime, v.MinTime)
+		meta.MaxTime = common.MaxInt64(v.Meta.MaxTime, v.MaxTime+1)
+	}
+	meta.Stats.NumSeries = v.Stats.NumSeries
+	meta.Stats.NumChunks = v.Stats.NumChunks
+	meta.Stats.NumSamples = v.Stats.NumSamples
+
+	return meta, writeBlockMeta(v.Dir, meta)
+}
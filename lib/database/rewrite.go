@@ -0,0 +1,281 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	maxRewriteSamplesPerCommit = 15000
+)
+
+// LabelsRewriter returns the new labels of a series, or nil to drop the series.
+type LabelsRewriter func(labels.Labels) labels.Labels
+
+// RewriteStats describes the changes made to the series of a block by a rewrite.
+type RewriteStats struct {
+	Series  int
+	Changed int
+	Dropped int
+	Merged  int
+}
+
+// Unchanged returns whether the rewrite didn't change any series.
+func (s RewriteStats) Unchanged() bool {
+	return s.Changed == 0 && s.Dropped == 0 && s.Merged == 0
+}
+
+// Empty returns whether the rewrite dropped every series.
+func (s RewriteStats) Empty() bool {
+	return s.Dropped == s.Series
+}
+
+type seriesGroup struct {
+	metric labels.Labels
+	refs   []storage.SeriesRef
+}
+
+type rewriteSample struct {
+	t int64
+	v float64
+}
+
+// RelabelBlock writes a copy of the block, with the series rewritten, to outputDir.  Series which are rewritten to the
+// same labels are merged.  The copy keeps the time range, compaction level and sources of the block, and lists the
+// block as its parent, so it can be moved next to the block before the block is removed.  Prometheus deletes the
+// parents of the blocks it loads, so a replacement interrupted between the two steps is completed on startup.  Nothing
+// is written when the rewrite doesn't change any series or drops every series, or when dryRun is set.
+func RelabelBlock(ctx context.Context, blockDir string, outputDir string, rewrite LabelsRewriter, dryRun bool) (RewriteStats, error) {
+	b, err := tsdb.OpenBlock(log.NewNopLogger(), blockDir, nil)
+	if err != nil {
+		return RewriteStats{}, errors.Wrap(err, "failed to open block: %s", blockDir)
+	}
+	defer func(b *tsdb.Block) {
+		_ = b.Close()
+	}(b)
+	ir, err := b.Index()
+	if err != nil {
+		return RewriteStats{}, errors.Wrap(err, "failed to open index")
+	}
+	defer func(ir tsdb.IndexReader) {
+		_ = ir.Close()
+	}(ir)
+	groups, stats, err := groupSeries(ir, rewrite)
+	if err != nil {
+		return stats, err
+	}
+	if dryRun || stats.Unchanged() || stats.Empty() {
+		return stats, nil
+	}
+
+	cr, err := b.Chunks()
+	if err != nil {
+		return stats, errors.Wrap(err, "failed to open chunks")
+	}
+	defer func(cr tsdb.ChunkReader) {
+		_ = cr.Close()
+	}(cr)
+	tr, err := b.Tombstones()
+	if err != nil {
+		return stats, errors.Wrap(err, "failed to read tombstones")
+	}
+	defer func(tr tombstones.Reader) {
+		_ = tr.Close()
+	}(tr)
+
+	meta := b.Meta()
+	uid, err := writeBlock(ctx, outputDir, meta.MaxTime-meta.MinTime, groups, ir, cr, tr)
+	if err != nil {
+		return stats, err
+	}
+	if uid == (ulid.ULID{}) {
+		return stats, nil
+	}
+
+	newDir := filepath.Join(outputDir, uid.String())
+	written, err := readBlockMeta(newDir)
+	if err != nil {
+		return stats, err
+	}
+	written.MinTime = meta.MinTime
+	written.MaxTime = meta.MaxTime
+	written.Compaction = meta.Compaction
+	written.Compaction.Parents = []tsdb.BlockDesc{{ULID: meta.ULID, MinTime: meta.MinTime, MaxTime: meta.MaxTime}}
+	return stats, writeBlockMeta(newDir, written)
+}
+
+// groupSeries groups the series of the index by their rewritten labels, sorted by the rewritten labels.
+func groupSeries(ir tsdb.IndexReader, rewrite LabelsRewriter) ([]*seriesGroup, RewriteStats, error) {
+	var stats RewriteStats
+	p, err := ir.Postings(index.AllPostingsKey())
+	if err != nil {
+		return nil, stats, errors.Wrap(err, "failed to read postings")
+	}
+	byHash := map[uint64][]*seriesGroup{}
+	var groups []*seriesGroup
+	var lset labels.Labels
+	var chks []chunks.Meta
+	for p.Next() {
+		if ir.Series(p.At(), &lset, &chks) != nil {
+			continue
+		}
+		stats.Series++
+		metric := rewrite(lset)
+		if metric == nil {
+			stats.Dropped++
+			continue
+		}
+		if !labels.Equal(metric, lset) {
+			stats.Changed++
+		}
+		hash := metric.Hash()
+		var group *seriesGroup
+		for _, g := range byHash[hash] {
+			if labels.Equal(g.metric, metric) {
+				group = g
+				stats.Merged++
+				break
+			}
+		}
+		if group == nil {
+			group = &seriesGroup{metric: metric.Copy()}
+			byHash[hash] = append(byHash[hash], group)
+			groups = append(groups, group)
+		}
+		group.refs = append(group.refs, p.At())
+	}
+	if err = p.Err(); err != nil {
+		return nil, stats, errors.Wrap(err, "failed to read postings")
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return labels.Compare(groups[i].metric, groups[j].metric) < 0
+	})
+	return groups, stats, nil
+}
+
+// writeBlock writes the samples of the groups of series to a new block in dir.  Unreadable chunks and deleted samples
+// are skipped, and the samples of each group are sorted and deduplicated.  An empty ULID is returned when there were
+// no samples.
+func writeBlock(ctx context.Context, dir string, blockDuration int64, groups []*seriesGroup, ir tsdb.IndexReader, cr tsdb.ChunkReader, tr tombstones.Reader) (ulid.ULID, error) {
+	blockSize := common.MaxInt64(blockDuration, DefaultBlockDuration)
+	writer, err := tsdb.NewBlockWriter(log.NewNopLogger(), dir, 2*blockSize)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "failed to create block writer")
+	}
+	defer func(writer *tsdb.BlockWriter) {
+		_ = writer.Close()
+	}(writer)
+
+	appender := writer.Appender(ctx)
+	var pending int
+	for _, group := range groups {
+		samples, errS := readSamples(group.refs, ir, cr, tr)
+		if errS != nil {
+			return ulid.ULID{}, errors.Wrap(errS, "failed to read samples of %s", group.metric)
+		}
+		for _, s := range samples {
+			if _, err = appender.Append(0, group.metric, s.t, s.v); err != nil && err != storage.ErrDuplicateSampleForTimestamp {
+				return ulid.ULID{}, errors.Wrap(err, "failed to append sample of %s", group.metric)
+			}
+			pending++
+			if pending >= maxRewriteSamplesPerCommit {
+				if err = appender.Commit(); err != nil {
+					return ulid.ULID{}, errors.Wrap(err, "failed to commit")
+				}
+				appender = writer.Appender(ctx)
+				pending = 0
+			}
+		}
+	}
+	if err = appender.Commit(); err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "failed to commit")
+	}
+	uid, err := writer.Flush(ctx)
+	return uid, errors.Wrap(err, "failed to flush block writer")
+}
+
+// readSamples returns the sorted samples of the readable chunks of the series, excluding the deleted samples.
+func readSamples(refs []storage.SeriesRef, ir tsdb.IndexReader, cr tsdb.ChunkReader, tr tombstones.Reader) ([]rewriteSample, error) {
+	var samples []rewriteSample
+	var lset labels.Labels
+	var chks []chunks.Meta
+	for _, ref := range refs {
+		if err := ir.Series(ref, &lset, &chks); err != nil {
+			return nil, err
+		}
+		deleted, err := tr.Get(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, chk := range chks {
+			c, errC := cr.Chunk(chk.Ref)
+			if errC != nil {
+				continue
+			}
+			it := c.Iterator(nil)
+			for it.Next() {
+				t, v := it.At()
+				if !isDeleted(t, deleted) {
+					samples = append(samples, rewriteSample{t: t, v: v})
+				}
+			}
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].t < samples[j].t
+	})
+	return samples, nil
+}
+
+func isDeleted(t int64, deleted tombstones.Intervals) bool {
+	for _, d := range deleted {
+		if d.InBounds(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func readBlockMeta(dir string) (*tsdb.BlockMeta, error) {
+	b, err := os.ReadFile(filepath.Join(dir, metaFilename))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read %s", metaFilename)
+	}
+	var meta tsdb.BlockMeta
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return nil, errors.Wrap(err, "failed to parse %s", metaFilename)
+	}
+	return &meta, nil
+}
+
+// writeBlockMeta replaces the meta.json of the block, using a temporary file so the change appears atomic.
+func writeBlockMeta(dir string, meta *tsdb.BlockMeta) error {
+	meta.Version = metaVersion1
+	return writeMetaFile(dir, meta)
+}
+
+func writeMetaFile(dir string, meta interface{}) error {
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal %s", metaFilename)
+	}
+	path := filepath.Join(dir, metaFilename)
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o666); err != nil {
+		return errors.Wrap(err, "failed to write %s", tmp)
+	}
+	return errors.Wrap(os.Rename(tmp, path), "failed to replace %s", path)
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"github.com/prometheus/prometheus/model/labels"
+	"k8s.io/klog/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type DropReason string
+
+const (
+	OutOfOrderDrop  DropReason = "out of order"
+	OutOfBoundsDrop DropReason = "out of bounds"
+	DuplicateDrop   DropReason = "duplicate timestamp"
+)
+
+var (
+	dropReasons = []DropReason{OutOfOrderDrop, OutOfBoundsDrop, DuplicateDrop}
+)
+
+// AppendStats counts the samples which were appended and the samples which were dropped by the appenders of an
+// AppendManager, and the bytes they wrote.  Dropped samples are also counted by metric name when verbose logging is
+// enabled.
+type AppendStats struct {
+	appended uint64
+	written  uint64
+	mtx      sync.Mutex
+	dropped  map[DropReason]uint64
+	byMetric map[DropReason]map[string]uint64
+}
+
+func NewAppendStats() *AppendStats {
+	return &AppendStats{
+		dropped:  map[DropReason]uint64{},
+		byMetric: map[DropReason]map[string]uint64{},
+	}
+}
+
+func (s *AppendStats) Appended() uint64 {
+	return atomic.LoadUint64(&s.appended)
+}
+
+// Dropped returns the number of dropped samples for the reasons, or for every reason when none are given.
+func (s *AppendStats) Dropped(reasons ...DropReason) uint64 {
+	if len(reasons) == 0 {
+		reasons = dropReasons
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var dropped uint64
+	for _, reason := range reasons {
+		dropped += s.dropped[reason]
+	}
+	return dropped
+}
+
+func (s *AppendStats) AddAppended() {
+	atomic.AddUint64(&s.appended, 1)
+}
+
+// Written returns the number of bytes written to blocks or sent to remote write endpoints.
+func (s *AppendStats) Written() uint64 {
+	return atomic.LoadUint64(&s.written)
+}
+
+func (s *AppendStats) AddWritten(bytes uint64) {
+	atomic.AddUint64(&s.written, bytes)
+}
+
+func (s *AppendStats) AddDropped(reason DropReason, sample labels.Labels, t int64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.dropped[reason]++
+	if klog.V(1).Enabled() {
+		name := sample.Get(labels.MetricName)
+		if s.byMetric[reason] == nil {
+			s.byMetric[reason] = map[string]uint64{}
+		}
+		s.byMetric[reason][name]++
+		klog.V(2).Infof("Dropped %s sample of %s at %d", reason, sample, t)
+	}
+}
+
+// Log logs the number of appended and dropped samples, and at higher verbosity the dropped samples by metric name.
+func (s *AppendStats) Log() {
+	dropped := s.Dropped()
+	if dropped == 0 {
+		klog.V(0).Infof("Appended %d samples", s.Appended())
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	klog.V(0).Infof("Appended %d samples and dropped %d samples: %d %s, %d %s, %d %s", s.Appended(), dropped,
+		s.dropped[OutOfOrderDrop], OutOfOrderDrop, s.dropped[OutOfBoundsDrop], OutOfBoundsDrop,
+		s.dropped[DuplicateDrop], DuplicateDrop)
+	for _, reason := range dropReasons {
+		var names []string
+		for name := range s.byMetric[reason] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			klog.V(1).Infof("Dropped %d %s samples of %s", s.byMetric[reason][name], reason, name)
+		}
+	}
+}
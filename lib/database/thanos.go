@@ -0,0 +1,122 @@
+package database
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/tsdb"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	thanosSource        = "promutil"
+	tombstonesFilename  = "tombstones"
+	thanosRawResolution = 0
+	thanosMetaVersion1  = 1
+)
+
+// ThanosConfig describes the thanos metadata added to blocks.  In the bucket layout, blocks only contain the files
+// which thanos uploads to object storage.
+type ThanosConfig struct {
+	ExternalLabels map[string]string
+	BucketLayout   bool
+}
+
+type thanosBlockMeta struct {
+	tsdb.BlockMeta
+	Thanos thanosMeta `json:"thanos"`
+}
+
+type thanosMeta struct {
+	Version    int               `json:"version"`
+	Labels     map[string]string `json:"labels"`
+	Downsample thanosDownsample  `json:"downsample"`
+	Source     string            `json:"source"`
+	Files      []thanosFile      `json:"files,omitempty"`
+}
+
+type thanosDownsample struct {
+	Resolution int64 `json:"resolution"`
+}
+
+type thanosFile struct {
+	RelPath   string `json:"rel_path"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+}
+
+// WriteThanosMeta adds the thanos section to the meta.json of the blocks in dir.  The blocks hold raw samples, so their
+// downsample resolution is always zero.
+func WriteThanosMeta(dir string, c ThanosConfig) error {
+	blockDirs, err := BlockDirectories(dir)
+	if err != nil {
+		return err
+	}
+	for _, blockDir := range blockDirs {
+		if err = writeThanosMeta(blockDir, c); err != nil {
+			return errors.Wrap(err, "failed to write thanos meta of block %s", filepath.Base(blockDir))
+		}
+	}
+	return nil
+}
+
+func writeThanosMeta(dir string, c ThanosConfig) error {
+	meta, err := readBlockMeta(dir)
+	if err != nil {
+		return err
+	}
+	externalLabels := c.ExternalLabels
+	if externalLabels == nil {
+		externalLabels = map[string]string{}
+	}
+	thanos := &thanosBlockMeta{
+		BlockMeta: *meta,
+		Thanos: thanosMeta{
+			Version:    thanosMetaVersion1,
+			Labels:     externalLabels,
+			Downsample: thanosDownsample{Resolution: thanosRawResolution},
+			Source:     thanosSource,
+		},
+	}
+	if c.BucketLayout {
+		err = os.Remove(filepath.Join(dir, tombstonesFilename))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove %s", tombstonesFilename)
+		}
+		if thanos.Thanos.Files, err = blockFiles(dir); err != nil {
+			return err
+		}
+	}
+	return writeMetaFile(dir, thanos)
+}
+
+// blockFiles lists the files of the block with their sizes, except for the meta.json which is listed without size, as
+// thanos does.
+func blockFiles(dir string) ([]thanosFile, error) {
+	files := []thanosFile{{RelPath: metaFilename}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() == metaFilename {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, thanosFile{RelPath: filepath.ToSlash(rel), SizeBytes: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list files of %s", dir)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].RelPath < files[j].RelPath
+	})
+	return files, nil
+}
@@ -0,0 +1,303 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	metaFilename        = "meta.json"
+	indexFilename       = "index"
+	chunksDirname       = "chunks"
+	metaVersion1        = 1
+	maxProblemsPerBlock = 20
+)
+
+type ProblemType string
+
+const (
+	MetaProblem      ProblemType = "meta"
+	IndexProblem     ProblemType = "index"
+	ChunkProblem     ProblemType = "chunk"
+	TimeRangeProblem ProblemType = "time range"
+	OverlapProblem   ProblemType = "overlap"
+)
+
+type Problem struct {
+	Type    ProblemType
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Message)
+}
+
+// BlockVerification describes the problems found in a block, along with the time range and stats of the samples it
+// actually contains.
+type BlockVerification struct {
+	Dir        string
+	ULID       ulid.ULID
+	Meta       *tsdb.BlockMeta
+	Unreadable bool
+	MinTime    int64
+	MaxTime    int64
+	Stats      tsdb.BlockStats
+	Problems   []Problem
+	omitted    int
+}
+
+// VerifyBlocks verifies every block in dir.  Besides the problems of the individual blocks, blocks which overlap
+// other blocks are reported.
+func VerifyBlocks(dir string) ([]*BlockVerification, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read directory: %s", dir)
+	}
+	var verifications []*BlockVerification
+	for _, f := range files {
+		if isBlockDir(f) {
+			verifications = append(verifications, VerifyBlock(filepath.Join(dir, f.Name())))
+		}
+	}
+
+	var metas []tsdb.BlockMeta
+	byULID := map[ulid.ULID]*BlockVerification{}
+	for _, v := range verifications {
+		if v.Meta != nil {
+			metas = append(metas, *v.Meta)
+			byULID[v.Meta.ULID] = v
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	for _, overlap := range tsdb.OverlappingBlocks(metas) {
+		for _, m := range overlap {
+			var others []string
+			for _, o := range overlap {
+				if o.ULID != m.ULID {
+					others = append(others, o.ULID.String())
+				}
+			}
+			byULID[m.ULID].addProblem(OverlapProblem, "overlaps with %s", strings.Join(others, ", "))
+		}
+	}
+	return verifications, nil
+}
+
+// VerifyBlock verifies the meta.json, the consistency of the index, the CRCs and ordering of the chunks, and that the
+// samples are within the time range of the block.
+func VerifyBlock(dir string) *BlockVerification {
+	v := &BlockVerification{
+		Dir:     dir,
+		MinTime: math.MaxInt64,
+		MaxTime: math.MinInt64,
+	}
+	v.ULID, _ = ulid.ParseStrict(filepath.Base(dir))
+	v.verifyMeta()
+
+	ir, err := index.NewFileReader(filepath.Join(dir, indexFilename))
+	if err != nil {
+		v.Unreadable = true
+		v.addProblem(IndexProblem, "failed to open index: %v", err)
+		return v
+	}
+	defer func(ir *index.Reader) {
+		_ = ir.Close()
+	}(ir)
+	cr, err := chunks.NewDirReader(filepath.Join(dir, chunksDirname), nil)
+	if err != nil {
+		v.Unreadable = true
+		v.addProblem(ChunkProblem, "failed to open chunks: %v", err)
+		return v
+	}
+	defer func(cr *chunks.Reader) {
+		_ = cr.Close()
+	}(cr)
+
+	if err = v.verifySeries(ir, cr); err != nil {
+		v.Unreadable = true
+		v.addProblem(IndexProblem, "failed to read postings: %v", err)
+		return v
+	}
+	v.verifyTimeRange()
+	return v
+}
+
+// NeedsRewrite returns whether the index or chunks of the block are inconsistent, so the block must be rewritten.
+func (v *BlockVerification) NeedsRewrite() bool {
+	return v.Has(IndexProblem, ChunkProblem)
+}
+
+// NeedsMeta returns whether the meta.json of the block is missing, invalid or doesn't match the samples.
+func (v *BlockVerification) NeedsMeta() bool {
+	return v.Has(MetaProblem, TimeRangeProblem)
+}
+
+// Has returns whether the block has any problems of the types.
+func (v *BlockVerification) Has(types ...ProblemType) bool {
+	for _, p := range v.Problems {
+		for _, t := range types {
+			if p.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ProblemCount returns the number of problems, including those which were omitted from Problems.
+func (v *BlockVerification) ProblemCount() int {
+	return len(v.Problems) + v.omitted
+}
+
+func (v *BlockVerification) String() string {
+	if v.Unreadable || v.Stats.NumSamples == 0 {
+		return filepath.Base(v.Dir)
+	}
+	return fmt.Sprintf("%s from %s (%d series, %d samples)", filepath.Base(v.Dir),
+		common.FormatDateRange(v.MinTime, v.MaxTime), v.Stats.NumSeries, v.Stats.NumSamples)
+}
+
+func (v *BlockVerification) addProblem(problemType ProblemType, format string, args ...interface{}) {
+	if len(v.Problems) >= maxProblemsPerBlock {
+		v.omitted++
+		return
+	}
+	v.Problems = append(v.Problems, Problem{Type: problemType, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *BlockVerification) verifyMeta() {
+	b, err := os.ReadFile(filepath.Join(v.Dir, metaFilename))
+	if err != nil {
+		v.addProblem(MetaProblem, "failed to read %s: %v", metaFilename, err)
+		return
+	}
+	var meta tsdb.BlockMeta
+	if err = json.Unmarshal(b, &meta); err != nil {
+		v.addProblem(MetaProblem, "failed to parse %s: %v", metaFilename, err)
+		return
+	}
+	if meta.Version != metaVersion1 {
+		v.addProblem(MetaProblem, "unexpected version %d", meta.Version)
+		return
+	}
+	if meta.ULID != v.ULID {
+		v.addProblem(MetaProblem, "ULID %s doesn't match the block directory", meta.ULID)
+		return
+	}
+	if meta.MinTime >= meta.MaxTime {
+		v.addProblem(MetaProblem, "min time %d is not before max time %d", meta.MinTime, meta.MaxTime)
+		return
+	}
+	v.Meta = &meta
+}
+
+func (v *BlockVerification) verifySeries(ir *index.Reader, cr *chunks.Reader) error {
+	p, err := ir.Postings(index.AllPostingsKey())
+	if err != nil {
+		return err
+	}
+	var previous labels.Labels
+	var lset labels.Labels
+	var chks []chunks.Meta
+	for p.Next() {
+		if err = ir.Series(p.At(), &lset, &chks); err != nil {
+			v.addProblem(IndexProblem, "failed to read series %d: %v", p.At(), err)
+			continue
+		}
+		if err = validateLabels(lset); err != nil {
+			v.addProblem(IndexProblem, "series %s: %v", lset, err)
+		}
+		if previous != nil && labels.Compare(previous, lset) >= 0 {
+			v.addProblem(IndexProblem, "series %s is out of order or duplicated", lset)
+		}
+		previous = lset.Copy()
+
+		var samples uint64
+		for i, chk := range chks {
+			if chk.MinTime > chk.MaxTime {
+				v.addProblem(IndexProblem, "series %s: chunk %d has min time %d after max time %d", lset, i, chk.MinTime, chk.MaxTime)
+			}
+			if i > 0 && chk.MinTime <= chks[i-1].MaxTime {
+				v.addProblem(ChunkProblem, "series %s: chunk %d overlaps the previous chunk", lset, i)
+			}
+			samples += v.verifyChunk(cr, lset, i, chk)
+		}
+		if samples > 0 {
+			v.Stats.NumSeries++
+			v.Stats.NumChunks += uint64(len(chks))
+			v.Stats.NumSamples += samples
+		}
+	}
+	return p.Err()
+}
+
+func (v *BlockVerification) verifyChunk(cr *chunks.Reader, lset labels.Labels, i int, chk chunks.Meta) uint64 {
+	c, err := cr.Chunk(chk.Ref)
+	if err != nil {
+		v.addProblem(ChunkProblem, "series %s: failed to read chunk %d: %v", lset, i, err)
+		return 0
+	}
+	var samples uint64
+	previous := int64(math.MinInt64)
+	it := c.Iterator(nil)
+	for it.Next() {
+		t, _ := it.At()
+		if t <= previous {
+			v.addProblem(ChunkProblem, "series %s: chunk %d has out of order sample at %s", lset, i, common.FormatDate(t))
+		}
+		if t < chk.MinTime || t > chk.MaxTime {
+			v.addProblem(ChunkProblem, "series %s: chunk %d has sample at %s outside of the chunk time range", lset, i, common.FormatDate(t))
+		}
+		previous = t
+		v.MinTime = common.MinInt64(v.MinTime, t)
+		v.MaxTime = common.MaxInt64(v.MaxTime, t)
+		samples++
+	}
+	if err = it.Err(); err != nil {
+		v.addProblem(ChunkProblem, "series %s: failed to decode chunk %d: %v", lset, i, err)
+	}
+	return samples
+}
+
+func (v *BlockVerification) verifyTimeRange() {
+	if v.Meta == nil {
+		return
+	}
+	if v.Stats.NumSamples > 0 && (v.MinTime < v.Meta.MinTime || v.MaxTime >= v.Meta.MaxTime) {
+		v.addProblem(TimeRangeProblem, "samples from %s are outside of the block time range %s",
+			common.FormatDateRange(v.MinTime, v.MaxTime), common.FormatDateRange(v.Meta.MinTime, v.Meta.MaxTime))
+	}
+	if v.Meta.Stats.NumSeries != v.Stats.NumSeries || v.Meta.Stats.NumSamples != v.Stats.NumSamples {
+		v.addProblem(MetaProblem, "stats report %d series and %d samples, but the block contains %d series and %d samples",
+			v.Meta.Stats.NumSeries, v.Meta.Stats.NumSamples, v.Stats.NumSeries, v.Stats.NumSamples)
+	}
+}
+
+func validateLabels(lset labels.Labels) error {
+	if len(lset) == 0 {
+		return errors.New("series has no labels")
+	}
+	for i, l := range lset {
+		if l.Name == "" || l.Value == "" {
+			return errors.New("empty label name or value")
+		}
+		if i > 0 && lset[i-1].Name >= l.Name {
+			return errors.New("labels are not sorted or are duplicated")
+		}
+	}
+	return nil
+}
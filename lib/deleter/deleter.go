@@ -0,0 +1,112 @@
+package deleter
+
+import (
+	"context"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"k8s.io/klog/v2"
+	"sort"
+)
+
+func NewDeleter() command.Task[config.DeleteConfig] {
+	return &deleter{}
+}
+
+type deleter struct {
+}
+
+func (t *deleter) Run(c *config.DeleteConfig) error {
+	mint := timestamp.FromTime(c.Start)
+	maxt := timestamp.FromTime(c.End)
+	var expressions []string
+	for expression := range c.Matchers {
+		expressions = append(expressions, expression)
+	}
+	sort.Strings(expressions)
+
+	series, samples, err := countSeries(c.Directory, mint, maxt, expressions, c.Matchers, c.DryRun)
+	if err != nil {
+		return err
+	}
+	if c.DryRun {
+		klog.V(0).Infof("Would delete %d samples from %d series from %s", samples, series, common.FormatDateRange(mint, maxt))
+		return nil
+	}
+
+	var matcherSets [][]*labels.Matcher
+	for _, expression := range expressions {
+		matcherSets = append(matcherSets, c.Matchers[expression])
+	}
+	if c.CleanTombstones {
+		klog.V(0).Infof("Cleaning tombstones")
+	}
+	if err = database.DeleteSeries(context.Background(), c.Directory, mint, maxt, matcherSets, c.CleanTombstones); err != nil {
+		return errors.Wrap(err, "failed to delete series")
+	}
+	klog.V(0).Infof("Deleted %d samples from %d series from %s", samples, series, common.FormatDateRange(mint, maxt))
+	return nil
+}
+
+// countSeries reports the series, and their number of samples, which match the matchers within the time range.  The
+// matched series are only listed at higher verbosity, unless dryRun is set.
+func countSeries(dir string, mint int64, maxt int64, expressions []string, matchers map[string][]*labels.Matcher, dryRun bool) (int, int, error) {
+	verbosity := klog.Level(2)
+	if dryRun {
+		verbosity = 0
+	}
+
+	db, err := database.NewReadOnlyDatabase(dir)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to open db")
+	}
+	defer func(db database.ReadOnlyDatabase) {
+		_ = db.Close()
+	}(db)
+
+	q, err := db.Querier(mint, maxt)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to create querier")
+	}
+	defer func(q storage.Querier) {
+		_ = q.Close()
+	}(q)
+
+	seen := map[uint64]struct{}{}
+	var totalSamples int
+	for _, expression := range expressions {
+		ss := q.Select(true, &storage.SelectHints{Start: mint, End: maxt}, matchers[expression]...)
+		for ss.Next() {
+			s := ss.At()
+			hash := s.Labels().Hash()
+			if _, ok := seen[hash]; ok {
+				continue
+			}
+			it := s.Iterator()
+			var samples int
+			for it.Next() {
+				if t, _ := it.At(); t >= mint && t <= maxt {
+					samples++
+				}
+			}
+			if err = it.Err(); err != nil {
+				return 0, 0, errors.Wrap(err, "failed to read samples of %s", s.Labels())
+			}
+			if samples == 0 {
+				continue
+			}
+			seen[hash] = struct{}{}
+			totalSamples += samples
+			klog.V(verbosity).Infof("%s: %d samples", s.Labels(), samples)
+		}
+		if err = ss.Err(); err != nil {
+			return 0, 0, errors.Wrap(err, "failed to select '%s'", expression)
+		}
+	}
+	return len(seen), totalSamples, nil
+}
@@ -0,0 +1,182 @@
+package differ
+
+import (
+	"context"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/kadaan/promutil/lib/remote"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"math"
+	"sort"
+)
+
+func NewDiffer() command.Task[config.DiffConfig] {
+	return &differ{}
+}
+
+type differ struct {
+}
+
+func (t *differ) Run(c *config.DiffConfig) error {
+	matchers := c.Matchers
+	if len(matchers) == 0 {
+		matchers = map[string][]*labels.Matcher{
+			"all": {labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")},
+		}
+	}
+	var expressions []string
+	for expression := range matchers {
+		expressions = append(expressions, expression)
+	}
+	sort.Strings(expressions)
+
+	source, err := remote.NewSource("diff-source", c.SourceDirectory, c.Host, c.HTTPConfig, c.RateLimit.RequestsPerSecond,
+		c.RateLimit.SamplesPerSecond, c.RateLimit.SlowdownLatency)
+	if err != nil {
+		return errors.Wrap(err, "failed to create source")
+	}
+	defer func(source remote.Source) {
+		_ = source.Close()
+	}(source)
+	target, err := remote.NewSource("diff-target", c.TargetDirectory, c.TargetHost, c.HTTPConfig, c.RateLimit.RequestsPerSecond,
+		c.RateLimit.SamplesPerSecond, c.RateLimit.SlowdownLatency)
+	if err != nil {
+		return errors.Wrap(err, "failed to create target")
+	}
+	defer func(target remote.Source) {
+		_ = target.Close()
+	}(target)
+
+	r := newReport(c)
+	start := c.Start.UnixMilli()
+	end := c.End.UnixMilli()
+	compared := newSeriesSet()
+	for _, expression := range expressions {
+		sourceSeries := newSeriesSet()
+		err = source.Read(context.Background(), start, end, [][]*labels.Matcher{matchers[expression]}, func(metric labels.Labels, samples remote.SampleIterator) error {
+			if compared.contains(metric) {
+				return nil
+			}
+			points, errR := readPoints(start, end, samples)
+			if errR != nil {
+				return errors.Wrap(errR, "failed to read samples of %s", metric)
+			}
+			sourceSeries.add(metric, points)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to read %s from source", expression)
+		}
+		targetSeries := newSeriesSet()
+		err = target.Read(context.Background(), start, end, [][]*labels.Matcher{matchers[expression]}, func(metric labels.Labels, samples remote.SampleIterator) error {
+			if compared.contains(metric) {
+				return nil
+			}
+			points, errR := readPoints(start, end, samples)
+			if errR != nil {
+				return errors.Wrap(errR, "failed to read samples of %s", metric)
+			}
+			targetSeries.add(metric, points)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to read %s from target", expression)
+		}
+		for _, s := range targetSeries.series {
+			compared.add(s.metric, nil)
+			sourcePoints, _ := sourceSeries.match(s.metric)
+			r.add(s.metric, sourcePoints, s.points, c.Tolerance)
+		}
+		for _, s := range sourceSeries.series {
+			if !s.matched {
+				compared.add(s.metric, nil)
+				r.add(s.metric, s.points, nil, c.Tolerance)
+			}
+		}
+	}
+
+	r.log()
+	if err = r.write(c.OutputFile); err != nil {
+		return err
+	}
+	if r.Summary.Different > 0 {
+		return errors.New("found differences in %d series", r.Summary.Different)
+	}
+	return nil
+}
+
+func readPoints(start int64, end int64, samples remote.SampleIterator) ([]promql.Point, error) {
+	var points []promql.Point
+	for samples.Next() {
+		t, v := samples.At()
+		if t >= start && t <= end && !value.IsStaleNaN(v) {
+			points = append(points, promql.Point{T: t, V: v})
+		}
+	}
+	return points, samples.Err()
+}
+
+// equal returns whether the values are equal, or differ by at most the tolerance relative to the larger of them.
+func equal(a float64, b float64, tolerance float64) bool {
+	if a == b || (math.IsNaN(a) && math.IsNaN(b)) {
+		return true
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+type series struct {
+	metric  labels.Labels
+	points  []promql.Point
+	matched bool
+}
+
+// seriesSet holds series by their labels, as the sources don't return series in the same order.
+type seriesSet struct {
+	byHash map[uint64][]*series
+	series []*series
+}
+
+func newSeriesSet() *seriesSet {
+	return &seriesSet{
+		byHash: map[uint64][]*series{},
+	}
+}
+
+// add adds the series, or appends the points to the series when it was already added, as a series may be handed to
+// the handler more than once within a read.
+func (s *seriesSet) add(metric labels.Labels, points []promql.Point) {
+	if existing := s.get(metric); existing != nil {
+		existing.points = append(existing.points, points...)
+		return
+	}
+	hash := metric.Hash()
+	added := &series{metric: metric.Copy(), points: points}
+	s.byHash[hash] = append(s.byHash[hash], added)
+	s.series = append(s.series, added)
+}
+
+func (s *seriesSet) get(metric labels.Labels) *series {
+	for _, existing := range s.byHash[metric.Hash()] {
+		if labels.Equal(existing.metric, metric) {
+			return existing
+		}
+	}
+	return nil
+}
+
+func (s *seriesSet) contains(metric labels.Labels) bool {
+	return s.get(metric) != nil
+}
+
+// match marks the series as matched and returns its points.
+func (s *seriesSet) match(metric labels.Labels) ([]promql.Point, bool) {
+	existing := s.get(metric)
+	if existing == nil {
+		return nil, false
+	}
+	existing.matched = true
+	return existing.points, true
+}
@@ -0,0 +1,186 @@
+package differ
+
+import (
+	"encoding/json"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"k8s.io/klog/v2"
+	"math"
+	"os"
+	"strconv"
+)
+
+const (
+	sourceOnlyStatus = "source-only"
+	targetOnlyStatus = "target-only"
+	differentStatus  = "different"
+)
+
+type report struct {
+	Source    string       `json:"source"`
+	Target    string       `json:"target"`
+	Start     int64        `json:"start"`
+	End       int64        `json:"end"`
+	Tolerance float64      `json:"tolerance"`
+	Summary   summary      `json:"summary"`
+	Series    []seriesDiff `json:"series"`
+}
+
+type summary struct {
+	Series          int `json:"series"`
+	Different       int `json:"different"`
+	SourceSamples   int `json:"sourceSamples"`
+	TargetSamples   int `json:"targetSamples"`
+	SourceOnly      int `json:"sourceOnly"`
+	TargetOnly      int `json:"targetOnly"`
+	CountMismatches int `json:"countMismatches"`
+	ValueMismatches int `json:"valueMismatches"`
+}
+
+type seriesDiff struct {
+	Series           string            `json:"series"`
+	Status           string            `json:"status"`
+	SourceSamples    int               `json:"sourceSamples"`
+	TargetSamples    int               `json:"targetSamples"`
+	MissingInSource  int               `json:"missingInSource"`
+	MissingInTarget  int               `json:"missingInTarget"`
+	ValueDifferences int               `json:"valueDifferences"`
+	FirstDifference  *sampleDifference `json:"firstDifference,omitempty"`
+}
+
+// sampleDifference holds the values of a timestamp in the source and the target.  The values are strings, as NaN and
+// infinite values aren't valid JSON numbers, and are empty when the timestamp is missing.
+type sampleDifference struct {
+	Timestamp int64  `json:"timestamp"`
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+}
+
+func newReport(c *config.DiffConfig) *report {
+	return &report{
+		Source:    describeSide(c.SourceDirectory, c.Host.String()),
+		Target:    describeSide(c.TargetDirectory, c.TargetHost.String()),
+		Start:     c.Start.UnixMilli(),
+		End:       c.End.UnixMilli(),
+		Tolerance: c.Tolerance,
+		Series:    []seriesDiff{},
+	}
+}
+
+func describeSide(directory string, host string) string {
+	if directory != "" {
+		return directory
+	}
+	return host
+}
+
+// add compares the points of a series in the source and the target, and records the series when they differ.  Series
+// without points on either side are ignored.
+func (r *report) add(metric labels.Labels, source []promql.Point, target []promql.Point, tolerance float64) {
+	if len(source) == 0 && len(target) == 0 {
+		return
+	}
+	r.Summary.Series++
+	r.Summary.SourceSamples += len(source)
+	r.Summary.TargetSamples += len(target)
+	diff := seriesDiff{
+		Series:        metric.String(),
+		SourceSamples: len(source),
+		TargetSamples: len(target),
+	}
+	switch {
+	case len(target) == 0:
+		diff.Status = sourceOnlyStatus
+		diff.MissingInTarget = len(source)
+		r.Summary.SourceOnly++
+	case len(source) == 0:
+		diff.Status = targetOnlyStatus
+		diff.MissingInSource = len(target)
+		r.Summary.TargetOnly++
+	default:
+		diff.compare(source, target, tolerance)
+		if diff.MissingInSource == 0 && diff.MissingInTarget == 0 && diff.ValueDifferences == 0 {
+			return
+		}
+		diff.Status = differentStatus
+		if len(source) != len(target) {
+			r.Summary.CountMismatches++
+		}
+		if diff.ValueDifferences > 0 {
+			r.Summary.ValueMismatches++
+		}
+	}
+	r.Summary.Different++
+	klog.V(1).Infof("%s: %s with %d samples in the source and %d samples in the target", diff.Series, diff.Status,
+		diff.SourceSamples, diff.TargetSamples)
+	r.Series = append(r.Series, diff)
+}
+
+// compare matches the points of the source and the target by timestamp.
+func (d *seriesDiff) compare(source []promql.Point, target []promql.Point, tolerance float64) {
+	i, j := 0, 0
+	for i < len(source) || j < len(target) {
+		switch {
+		case j == len(target) || (i < len(source) && source[i].T < target[j].T):
+			d.MissingInTarget++
+			d.recordFirst(source[i].T, formatValue(source[i].V), "")
+			i++
+		case i == len(source) || target[j].T < source[i].T:
+			d.MissingInSource++
+			d.recordFirst(target[j].T, "", formatValue(target[j].V))
+			j++
+		default:
+			if !equal(source[i].V, target[j].V, tolerance) {
+				d.ValueDifferences++
+				d.recordFirst(source[i].T, formatValue(source[i].V), formatValue(target[j].V))
+			}
+			i++
+			j++
+		}
+	}
+}
+
+func (d *seriesDiff) recordFirst(t int64, source string, target string) {
+	if d.FirstDifference == nil {
+		d.FirstDifference = &sampleDifference{Timestamp: t, Source: source, Target: target}
+	}
+}
+
+func (r *report) log() {
+	klog.V(0).Infof("Compared %d series with %d samples in the source and %d samples in the target", r.Summary.Series,
+		r.Summary.SourceSamples, r.Summary.TargetSamples)
+	klog.V(0).Infof("Found %d series only in the source, %d series only in the target, %d series with different sample counts and %d series with different values",
+		r.Summary.SourceOnly, r.Summary.TargetOnly, r.Summary.CountMismatches, r.Summary.ValueMismatches)
+}
+
+// write writes the report as JSON to the file, unless the file is empty.
+func (r *report) write(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to create report: %s", filename)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(r), "failed to write report: %s", filename)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,76 @@
+package downsample
+
+import (
+	"github.com/prometheus/prometheus/promql"
+	"math"
+	"time"
+)
+
+// Window holds the aggregates of the points in the window ending at T, which starts after the end of the previous
+// window.
+type Window struct {
+	T        int64
+	Min      float64
+	Max      float64
+	Sum      float64
+	Count    int
+	increase float64
+	duration int64
+}
+
+// Rate returns the per-second rate of the points of the window, treating them as a counter.  Decreasing values are
+// treated as counter resets, and the last point of the previous window is used as the starting point when there is one.
+// The rate is only defined when the points span some time.
+func (w Window) Rate() (float64, bool) {
+	if w.duration <= 0 {
+		return 0, false
+	}
+	return w.increase / (float64(w.duration) / float64(time.Second/time.Millisecond)), true
+}
+
+// Aggregate aggregates the points into windows of the resolution, ending at the multiples of the resolution from start
+// to end.  Windows without points are omitted.
+func Aggregate(points []promql.Point, start int64, end int64, resolution int64) []Window {
+	if resolution <= 0 || len(points) == 0 {
+		return nil
+	}
+	var windows []Window
+	t := start
+	if r := t % resolution; r != 0 {
+		t += resolution - r
+	}
+	i := 0
+	for ; t <= end; t += resolution {
+		for i < len(points) && points[i].T <= t-resolution {
+			i++
+		}
+		if i == len(points) {
+			break
+		}
+		if points[i].T > t {
+			continue
+		}
+		w := Window{T: t, Min: math.Inf(1), Max: math.Inf(-1)}
+		previous := points[i]
+		if i > 0 && points[i-1].T > t-2*resolution {
+			previous = points[i-1]
+		}
+		first := previous.T
+		for ; i < len(points) && points[i].T <= t; i++ {
+			p := points[i]
+			w.Min = math.Min(w.Min, p.V)
+			w.Max = math.Max(w.Max, p.V)
+			w.Sum += p.V
+			w.Count++
+			if p.V >= previous.V {
+				w.increase += p.V - previous.V
+			} else {
+				w.increase += p.V
+			}
+			w.duration = p.T - first
+			previous = p
+		}
+		windows = append(windows, w)
+	}
+	return windows
+}
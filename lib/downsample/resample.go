@@ -0,0 +1,27 @@
+package downsample
+
+import (
+	"github.com/prometheus/prometheus/promql"
+)
+
+// Resample aligns the points onto the steps from start to end, like the steps of a range query.  Each step takes the
+// value of the latest point at or before it, within the lookback delta.  Steps without such a point are omitted.
+func Resample(points []promql.Point, start int64, end int64, step int64, lookbackDelta int64) []promql.Point {
+	if step <= 0 || len(points) == 0 {
+		return points
+	}
+	resampled := make([]promql.Point, 0, (end-start)/step+1)
+	i := 0
+	for t := start; t <= end; t += step {
+		for i < len(points) && points[i].T <= t {
+			i++
+		}
+		if i == 0 {
+			continue
+		}
+		if p := points[i-1]; t-p.T <= lookbackDelta {
+			resampled = append(resampled, promql.Point{T: t, V: p.V})
+		}
+	}
+	return resampled
+}
@@ -0,0 +1,208 @@
+package downsampler
+
+import (
+	"context"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/block"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/downsample"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/kadaan/promutil/lib/remote"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	counterSuffixes = []string{"_total", "_count", "_sum", "_bucket"}
+)
+
+func NewDownsampler() command.Task[config.DownsampleConfig] {
+	return &downsampler{}
+}
+
+type downsampler struct {
+}
+
+func (t *downsampler) Run(c *config.DownsampleConfig) error {
+	if c.SourceDirectory == "" {
+		return errors.New("source directory must be specified")
+	}
+	if filepath.Clean(c.SourceDirectory) == filepath.Clean(c.OutputDirectory) {
+		return errors.New("source directory must differ from output directory")
+	}
+	db, err := database.NewReadOnlyDatabase(c.SourceDirectory)
+	if err != nil {
+		return errors.Wrap(err, "failed to open source directory")
+	}
+	defer func(db database.ReadOnlyDatabase) {
+		_ = db.Close()
+	}(db)
+
+	matchers := c.Matchers
+	if len(matchers) == 0 {
+		matchers = map[string][]*labels.Matcher{
+			"all": {labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")},
+		}
+	}
+	var data []*planData
+	for expression, m := range matchers {
+		data = append(data, &planData{expression: expression, matchers: m})
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].expression < data[j].expression
+	})
+
+	plannerConfig := block.NewPlannerConfig(c.OutputDirectory, c.Start, c.End, c.Resolution, int(c.Parallelism), block.WriterOptions(c.BlockWriter))
+	generator := &planGenerator{data: data}
+	executorCreator := &planExecutorCreator{
+		db:           db,
+		resolution:   c.Resolution.Milliseconds(),
+		suffix:       "_" + model.Duration(c.Resolution).String(),
+		aggregations: c.Aggregations,
+	}
+	output := block.NewOutput(plannerConfig, c.RemoteWriteConfig)
+	writer := block.NewPlannedBlockWriter[planData](plannerConfig, output, generator, executorCreator)
+	return writer.Run()
+}
+
+type planData struct {
+	expression string
+	matchers   []*labels.Matcher
+}
+
+func (p planData) String() string {
+	return p.expression
+}
+
+type planGenerator struct {
+	data []*planData
+}
+
+func (p *planGenerator) Generate(chunkStart int64, chunkEnd int64, stepDuration int64) []block.PlanEntry[planData] {
+	var planEntries []block.PlanEntry[planData]
+	for _, d := range p.data {
+		planEntries = append(planEntries, block.NewPlanEntry("downsample", chunkStart, chunkEnd, stepDuration, d))
+	}
+	return planEntries
+}
+
+type planExecutorCreator struct {
+	db           database.ReadOnlyDatabase
+	resolution   int64
+	suffix       string
+	aggregations []config.Aggregation
+}
+
+func (p *planExecutorCreator) Create(_ string, appender database.Appender) (block.PlanExecutor[planData], error) {
+	return &planExecutor{
+		db:           p.db,
+		appender:     appender,
+		resolution:   p.resolution,
+		suffix:       p.suffix,
+		aggregations: p.aggregations,
+	}, nil
+}
+
+type planExecutor struct {
+	db           database.ReadOnlyDatabase
+	appender     database.Appender
+	resolution   int64
+	suffix       string
+	aggregations []config.Aggregation
+}
+
+// Execute aggregates the windows ending within the plan.  The samples of the window before the first one are read as
+// well, as the rate of a window starts from the last sample of the previous window.
+func (p *planExecutor) Execute(_ context.Context, _ block.PlanLogger[planData], plan block.PlanEntry[planData]) error {
+	start := plan.Start() - 2*p.resolution + 1
+	q, err := p.db.Querier(start, plan.End())
+	if err != nil {
+		return errors.Wrap(err, "failed to create querier")
+	}
+	defer func(q storage.Querier) {
+		_ = q.Close()
+	}(q)
+	hints := &storage.SelectHints{
+		Start: start,
+		End:   plan.End(),
+	}
+	ss := q.Select(true, hints, plan.Data().matchers...)
+	for ss.Next() {
+		series := ss.At()
+		if err = p.downsampleSeries(plan, series.Labels(), series.Iterator()); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(ss.Err(), "failed to select series")
+}
+
+func (p *planExecutor) downsampleSeries(plan block.PlanEntry[planData], metric labels.Labels, samples remote.SampleIterator) error {
+	name := metric.Get(labels.MetricName)
+	if name == "" {
+		return nil
+	}
+	var points []promql.Point
+	for samples.Next() {
+		t, v := samples.At()
+		if value.IsStaleNaN(v) || t > plan.End() {
+			continue
+		}
+		points = append(points, promql.Point{T: t, V: v})
+	}
+	if err := samples.Err(); err != nil {
+		return err
+	}
+	windows := downsample.Aggregate(points, plan.Start(), plan.End(), p.resolution)
+	if len(windows) == 0 {
+		return nil
+	}
+
+	counter := isCounter(name)
+	builder := labels.NewBuilder(metric)
+	sample := &promql.Sample{}
+	for _, aggregation := range p.aggregations {
+		if aggregation == config.RateAggregation && !counter {
+			continue
+		}
+		sample.Metric = builder.Set(labels.MetricName, name+":"+string(aggregation)+p.suffix).Labels()
+		for _, w := range windows {
+			sample.T = w.T
+			switch aggregation {
+			case config.MinAggregation:
+				sample.V = w.Min
+			case config.MaxAggregation:
+				sample.V = w.Max
+			case config.SumAggregation:
+				sample.V = w.Sum
+			case config.CountAggregation:
+				sample.V = float64(w.Count)
+			case config.RateAggregation:
+				rate, ok := w.Rate()
+				if !ok {
+					continue
+				}
+				sample.V = rate
+			}
+			if err := p.appender.Add(sample); err != nil {
+				return errors.Wrap(err, "failed to add sample: %s", sample)
+			}
+		}
+	}
+	return nil
+}
+
+func isCounter(name string) bool {
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
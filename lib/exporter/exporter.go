@@ -0,0 +1,146 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/downsample"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/kadaan/promutil/lib/remote"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	lookbackDelta = int64(5 * time.Minute / time.Millisecond)
+)
+
+func NewExporter() command.Task[config.ExportConfig] {
+	return &exporter{}
+}
+
+type exporter struct {
+}
+
+func (t *exporter) Run(c *config.ExportConfig) error {
+	matchers := c.Matchers
+	if len(matchers) == 0 {
+		matchers = map[string][]*labels.Matcher{
+			"all": {labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")},
+		}
+	}
+	var expressions []string
+	for expression := range matchers {
+		expressions = append(expressions, expression)
+	}
+	sort.Strings(expressions)
+
+	src, err := remote.NewSource("export", c.SourceDirectory, c.Host, c.HTTPConfig, c.RateLimit.RequestsPerSecond,
+		c.RateLimit.SamplesPerSecond, c.RateLimit.SlowdownLatency)
+	if err != nil {
+		return err
+	}
+	defer func(src remote.Source) {
+		_ = src.Close()
+	}(src)
+
+	var out io.Writer = os.Stdout
+	if c.OutputFile != "" {
+		f, errC := os.Create(c.OutputFile)
+		if errC != nil {
+			return errors.Wrap(errC, "failed to create output file: %s", c.OutputFile)
+		}
+		defer func(f *os.File) {
+			_ = f.Close()
+		}(f)
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	writer := newSeriesWriter(c.Format, bw)
+
+	start := c.Start.UnixMilli()
+	end := c.End.UnixMilli()
+	step := c.Step.Milliseconds()
+	exported := map[uint64][]labels.Labels{}
+	var seriesCount, sampleCount int
+	for _, expression := range expressions {
+		series, errR := readSeries(src, start, end, matchers[expression], exported)
+		if errR != nil {
+			return errors.Wrap(errR, "failed to export %s", expression)
+		}
+		for _, s := range series {
+			exported[s.metric.Hash()] = append(exported[s.metric.Hash()], s.metric)
+			points := s.points
+			if step > 0 {
+				points = downsample.Resample(points, start, end, step, lookbackDelta)
+			}
+			if len(points) == 0 {
+				continue
+			}
+			seriesCount++
+			sampleCount += len(points)
+			if err = writer.Write(s.metric, points); err != nil {
+				return err
+			}
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return errors.Wrap(err, "failed to write %s", c.Format)
+	}
+	if err = bw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write output")
+	}
+	klog.V(0).Infof("Exported %d series with %d samples", seriesCount, sampleCount)
+	return nil
+}
+
+type exportedSeries struct {
+	metric labels.Labels
+	points []promql.Point
+}
+
+// readSeries reads the samples of the series matching the matchers, in the order they are first read.  A series may
+// be handed to the handler more than once within a read, so its samples are appended.  Series already exported by an
+// earlier expression are skipped.
+func readSeries(src remote.Source, start int64, end int64, matchers []*labels.Matcher, exported map[uint64][]labels.Labels) ([]*exportedSeries, error) {
+	var series []*exportedSeries
+	read := map[uint64][]*exportedSeries{}
+	handler := func(metric labels.Labels, samples remote.SampleIterator) error {
+		hash := metric.Hash()
+		for _, m := range exported[hash] {
+			if labels.Equal(m, metric) {
+				return nil
+			}
+		}
+		var current *exportedSeries
+		for _, s := range read[hash] {
+			if labels.Equal(s.metric, metric) {
+				current = s
+				break
+			}
+		}
+		if current == nil {
+			current = &exportedSeries{metric: metric.Copy()}
+			read[hash] = append(read[hash], current)
+			series = append(series, current)
+		}
+		for samples.Next() {
+			ts, v := samples.At()
+			if ts >= start && ts <= end && !value.IsStaleNaN(v) {
+				current.points = append(current.points, promql.Point{T: ts, V: v})
+			}
+		}
+		return errors.Wrap(samples.Err(), "failed to read samples of %s", metric)
+	}
+	if err := src.Read(context.Background(), start, end, [][]*labels.Matcher{matchers}, handler); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
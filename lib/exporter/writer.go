@@ -0,0 +1,232 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/kadaan/promutil/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	csvTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+type seriesWriter interface {
+	Write(metric labels.Labels, points []promql.Point) error
+	Close() error
+}
+
+func newSeriesWriter(format config.ExportFormat, w io.Writer) seriesWriter {
+	switch format {
+	case config.CSVExportFormat:
+		return &csvSeriesWriter{w: csv.NewWriter(w)}
+	case config.WideCSVExportFormat:
+		return &wideCSVSeriesWriter{w: csv.NewWriter(w)}
+	case config.JSONLinesExportFormat:
+		return &jsonLinesSeriesWriter{encoder: json.NewEncoder(w)}
+	default:
+		return &openMetricsSeriesWriter{w: w}
+	}
+}
+
+// openMetricsSeriesWriter writes the series as OpenMetrics text with timestamps.  Each metric is declared as a family
+// of unknown type when its first series is written.
+type openMetricsSeriesWriter struct {
+	w      io.Writer
+	family string
+}
+
+func (s *openMetricsSeriesWriter) Write(metric labels.Labels, points []promql.Point) error {
+	name := metric.Get(labels.MetricName)
+	if name != s.family {
+		if _, err := fmt.Fprintf(s.w, "# TYPE %s unknown\n", name); err != nil {
+			return err
+		}
+		s.family = name
+	}
+	series := formatSeries(metric)
+	for _, p := range points {
+		ts := strconv.FormatFloat(float64(p.T)/1000, 'f', -1, 64)
+		if _, err := fmt.Fprintf(s.w, "%s %s %s\n", series, formatValue(p.V), ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *openMetricsSeriesWriter) Close() error {
+	_, err := fmt.Fprintln(s.w, "# EOF")
+	return err
+}
+
+// csvSeriesWriter writes a row for every sample.
+type csvSeriesWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (s *csvSeriesWriter) Write(metric labels.Labels, points []promql.Point) error {
+	if !s.headerWritten {
+		if err := s.w.Write([]string{"series", "timestamp", "value"}); err != nil {
+			return err
+		}
+		s.headerWritten = true
+	}
+	series := formatSeries(metric)
+	for _, p := range points {
+		if err := s.w.Write([]string{series, formatTimestamp(p.T), formatValue(p.V)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *csvSeriesWriter) Close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// wideCSVSeriesWriter writes a column for every series and a row for every timestamp.  As every series must be known
+// before the first row can be written, the series are buffered until the writer is closed.
+type wideCSVSeriesWriter struct {
+	w      *csv.Writer
+	series []string
+	points [][]promql.Point
+}
+
+func (s *wideCSVSeriesWriter) Write(metric labels.Labels, points []promql.Point) error {
+	s.series = append(s.series, formatSeries(metric))
+	s.points = append(s.points, points)
+	return nil
+}
+
+func (s *wideCSVSeriesWriter) Close() error {
+	timestamps := map[int64]struct{}{}
+	for _, points := range s.points {
+		for _, p := range points {
+			timestamps[p.T] = struct{}{}
+		}
+	}
+	sorted := make([]int64, 0, len(timestamps))
+	for t := range timestamps {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	if err := s.w.Write(append([]string{"timestamp"}, s.series...)); err != nil {
+		return err
+	}
+	next := make([]int, len(s.points))
+	row := make([]string, len(s.series)+1)
+	for _, t := range sorted {
+		row[0] = formatTimestamp(t)
+		for i, points := range s.points {
+			row[i+1] = ""
+			if next[i] < len(points) && points[next[i]].T == t {
+				row[i+1] = formatValue(points[next[i]].V)
+				next[i]++
+			}
+		}
+		if err := s.w.Write(row); err != nil {
+			return err
+		}
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// jsonLinesSeriesWriter writes a JSON object with the labels, values and timestamps for every series.
+type jsonLinesSeriesWriter struct {
+	encoder *json.Encoder
+}
+
+type jsonSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []jsonValue       `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// jsonValue is a sample value, which is written as a string when it isn't a valid JSON number.
+type jsonValue float64
+
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(formatValue(f))
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func (s *jsonLinesSeriesWriter) Write(metric labels.Labels, points []promql.Point) error {
+	series := jsonSeries{
+		Metric:     metric.Map(),
+		Values:     make([]jsonValue, len(points)),
+		Timestamps: make([]int64, len(points)),
+	}
+	for i, p := range points {
+		series.Values[i] = jsonValue(p.V)
+		series.Timestamps[i] = p.T
+	}
+	return s.encoder.Encode(series)
+}
+
+func (s *jsonLinesSeriesWriter) Close() error {
+	return nil
+}
+
+// formatSeries formats the labels like the OpenMetrics text format, with the metric name followed by the other labels.
+func formatSeries(metric labels.Labels) string {
+	var sb strings.Builder
+	sb.WriteString(metric.Get(labels.MetricName))
+	first := true
+	for _, l := range metric {
+		if l.Name == labels.MetricName {
+			continue
+		}
+		if first {
+			sb.WriteByte('{')
+			first = false
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(l.Name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueEscaper.Replace(l.Value))
+		sb.WriteByte('"')
+	}
+	if !first {
+		sb.WriteByte('}')
+	}
+	return sb.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func formatTimestamp(t int64) string {
+	return time.UnixMilli(t).UTC().Format(csvTimestampFormat)
+}
@@ -112,10 +112,11 @@ func (t *generator) Run(c *config.GenerateConfig) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to create metric specifications")
 	}
-	plannerConfig := block.NewPlannerConfig(c.OutputDirectory, c.Start, c.End, c.SampleInterval, int(c.Parallelism))
+	plannerConfig := block.NewPlannerConfig(c.OutputDirectory, c.Start, c.End, c.SampleInterval, int(c.Parallelism), block.WriterOptions(c.BlockWriter))
 	generator := &planGenerator{metrics: metrics}
 	executorCreator := &planExecutorCreator{}
-	writer := block.NewPlannedBlockWriter[planData](plannerConfig, generator, executorCreator)
+	output := block.NewOutput(plannerConfig, c.RemoteWriteConfig)
+	writer := block.NewPlannedBlockWriter[planData](plannerConfig, output, generator, executorCreator)
 	return writer.Run()
 }
 
@@ -0,0 +1,201 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/block"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"io"
+	"k8s.io/klog/v2"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	stdinFilename = "-"
+)
+
+func NewImporter() command.Task[config.ImportConfig] {
+	return &importer{}
+}
+
+type importer struct {
+}
+
+func (t *importer) Run(c *config.ImportConfig) error {
+	set := newSeriesSet()
+	report := &rejectionReport{}
+	for _, filename := range c.InputFiles {
+		if err := parseFile(filename, c, set, report); err != nil {
+			return err
+		}
+	}
+	if err := report.write(c.ErrorReportFile); err != nil {
+		return err
+	}
+	if len(report.rejections) > 0 {
+		if c.ErrorReportFile != "" {
+			klog.V(0).Infof("Rejected %d lines, see %s", len(report.rejections), c.ErrorReportFile)
+		} else {
+			klog.V(0).Infof("Rejected %d lines", len(report.rejections))
+		}
+	}
+	if len(set.series) == 0 {
+		return errors.New("no samples to import")
+	}
+	duplicates := set.sort()
+	if duplicates > 0 {
+		klog.V(0).Infof("Replaced %d samples with duplicate timestamps by the sample read last", duplicates)
+	}
+	klog.V(0).Infof("Importing %d series with %d samples from %s", len(set.series), set.samples-duplicates,
+		common.FormatDateRange(set.minTime, set.maxTime))
+
+	plannerConfig := block.NewPlannerConfig(c.OutputDirectory, time.UnixMilli(set.minTime).UTC(),
+		time.UnixMilli(set.maxTime+1).UTC(), time.Millisecond, int(c.Parallelism), block.WriterOptions(c.BlockWriter))
+	generator := &planGenerator{set: set}
+	executorCreator := &planExecutorCreator{}
+	output := block.NewOutput(plannerConfig, c.RemoteWriteConfig)
+	writer := block.NewPlannedBlockWriter[planData](plannerConfig, output, generator, executorCreator)
+	return writer.Run()
+}
+
+func parseFile(filename string, c *config.ImportConfig, set *seriesSet, report *rejectionReport) error {
+	var r io.Reader = os.Stdin
+	if filename != stdinFilename {
+		f, err := os.Open(filename)
+		if err != nil {
+			return errors.Wrap(err, "failed to open input file: %s", filename)
+		}
+		defer func(f *os.File) {
+			_ = f.Close()
+		}(f)
+		r = f
+	}
+	p := newParser(c.Format, c.CSVMapping)
+	err := p.Parse(bufio.NewReader(r), set.add, func(line int, text string, reason error) {
+		report.reject(filename, line, text, reason)
+	})
+	return errors.Wrap(err, "failed to parse input file: %s", filename)
+}
+
+type importedSeries struct {
+	metric labels.Labels
+	points []promql.Point
+}
+
+// seriesSet holds the parsed samples by series, as the input may contain the samples of a series in any order.
+type seriesSet struct {
+	byHash  map[uint64][]*importedSeries
+	series  []*importedSeries
+	samples int
+	minTime int64
+	maxTime int64
+}
+
+func newSeriesSet() *seriesSet {
+	return &seriesSet{
+		byHash:  map[uint64][]*importedSeries{},
+		minTime: math.MaxInt64,
+		maxTime: math.MinInt64,
+	}
+}
+
+func (s *seriesSet) add(metric labels.Labels, t int64, v float64) {
+	hash := metric.Hash()
+	var series *importedSeries
+	for _, is := range s.byHash[hash] {
+		if labels.Equal(is.metric, metric) {
+			series = is
+			break
+		}
+	}
+	if series == nil {
+		series = &importedSeries{metric: metric.Copy()}
+		s.byHash[hash] = append(s.byHash[hash], series)
+		s.series = append(s.series, series)
+	}
+	series.points = append(series.points, promql.Point{T: t, V: v})
+	s.samples++
+	s.minTime = common.MinInt64(s.minTime, t)
+	s.maxTime = common.MaxInt64(s.maxTime, t)
+}
+
+// sort sorts the samples of every series by timestamp, and returns the number of samples which were removed because
+// a later sample had the same timestamp.
+func (s *seriesSet) sort() int {
+	var duplicates int
+	for _, series := range s.series {
+		points := series.points
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].T < points[j].T
+		})
+		deduped := points[:0]
+		for i, p := range points {
+			if i+1 < len(points) && points[i+1].T == p.T {
+				duplicates++
+				continue
+			}
+			deduped = append(deduped, p)
+		}
+		series.points = deduped
+	}
+	return duplicates
+}
+
+type planData struct {
+	set *seriesSet
+}
+
+func (p planData) String() string {
+	return "samples"
+}
+
+type planGenerator struct {
+	set *seriesSet
+}
+
+func (p *planGenerator) Generate(chunkStart int64, chunkEnd int64, stepDuration int64) []block.PlanEntry[planData] {
+	d := &planData{
+		set: p.set,
+	}
+	return []block.PlanEntry[planData]{block.NewPlanEntry("import", chunkStart, chunkEnd, stepDuration, d)}
+}
+
+type planExecutorCreator struct {
+}
+
+func (p *planExecutorCreator) Create(_ string, appender database.Appender) (block.PlanExecutor[planData], error) {
+	return &planExecutor{
+		appender: appender,
+	}, nil
+}
+
+type planExecutor struct {
+	appender database.Appender
+}
+
+func (p *planExecutor) Execute(_ context.Context, _ block.PlanLogger[planData], plan block.PlanEntry[planData]) error {
+	sample := &promql.Sample{}
+	for _, series := range plan.Data().set.series {
+		points := series.points
+		i := sort.Search(len(points), func(i int) bool {
+			return points[i].T >= plan.Start()
+		})
+		for ; i < len(points) && points[i].T <= plan.End(); i++ {
+			sample.Metric = series.metric
+			sample.Point = points[i]
+			if err := p.appender.Add(sample); err != nil {
+				return errors.Wrap(err, "failed to add sample: %s", sample)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	stderrors "errors"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	promParser "github.com/prometheus/prometheus/promql/parser"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	unixTimestampFormat   = "unix"
+	unixMsTimestampFormat = "unix_ms"
+)
+
+type sampleHandler func(metric labels.Labels, t int64, v float64)
+
+type rejectHandler func(line int, text string, reason error)
+
+type parser interface {
+	Parse(r *bufio.Reader, handler sampleHandler, reject rejectHandler) error
+}
+
+func newParser(format config.ImportFormat, mapping config.CSVMapping) parser {
+	switch format {
+	case config.CSVImportFormat:
+		return &csvParser{mapping: mapping, series: map[string]labels.Labels{}}
+	case config.JSONLinesImportFormat:
+		return &jsonLinesParser{}
+	default:
+		return &openMetricsParser{}
+	}
+}
+
+func readLines(r *bufio.Reader, handler func(line int, text string)) error {
+	for line := 1; ; line++ {
+		text, err := r.ReadString('\n')
+		if text = strings.TrimRight(text, "\r\n"); text != "" {
+			handler(line, text)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func validateMetric(metric labels.Labels) error {
+	if metric.Get(labels.MetricName) == "" {
+		return errors.New("series has no metric name")
+	}
+	for _, l := range metric {
+		if l.Value == "" {
+			return errors.New("label %s has an empty value", l.Name)
+		}
+	}
+	return nil
+}
+
+// openMetricsParser parses OpenMetrics text.  Every sample must have a timestamp, and metadata and exemplars are
+// ignored.
+type openMetricsParser struct {
+}
+
+func (p *openMetricsParser) Parse(r *bufio.Reader, handler sampleHandler, reject rejectHandler) error {
+	return readLines(r, func(line int, text string) {
+		if strings.HasPrefix(text, "#") {
+			return
+		}
+		metric, t, v, err := p.parseLine(text)
+		if err != nil {
+			reject(line, text, err)
+			return
+		}
+		handler(metric, t, v)
+	})
+}
+
+func (p *openMetricsParser) parseLine(text string) (labels.Labels, int64, float64, error) {
+	end := seriesEnd(text)
+	if end < 0 {
+		return nil, 0, 0, errors.New("sample has no value")
+	}
+	metric, err := promParser.ParseMetric(text[:end])
+	if err != nil {
+		return nil, 0, 0, errors.Wrap(err, "invalid series")
+	}
+	if err = validateMetric(metric); err != nil {
+		return nil, 0, 0, err
+	}
+	fields := strings.Fields(text[end:])
+	if len(fields) > 2 && fields[2] == "#" {
+		fields = fields[:2]
+	}
+	switch len(fields) {
+	case 0:
+		return nil, 0, 0, errors.New("sample has no value")
+	case 1:
+		return nil, 0, 0, errors.New("sample has no timestamp")
+	case 2:
+	default:
+		return nil, 0, 0, errors.New("unexpected text after timestamp")
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, 0, 0, errors.New("invalid value %q", fields[0])
+	}
+	ts, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) {
+		return nil, 0, 0, errors.New("invalid timestamp %q", fields[1])
+	}
+	return metric, int64(math.Round(ts * 1000)), v, nil
+}
+
+// seriesEnd returns the index after the series of the sample line, or -1 when the line ends with the series.
+func seriesEnd(text string) int {
+	i := strings.IndexAny(text, "{ \t")
+	if i < 0 {
+		return -1
+	}
+	if text[i] != '{' {
+		return i
+	}
+	inQuotes := false
+	for ; i < len(text); i++ {
+		switch {
+		case inQuotes && text[i] == '\\':
+			i++
+		case text[i] == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && text[i] == '}':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// jsonLinesParser parses a JSON object with the labels, values and timestamps of a series on each line, as written by
+// the export command.
+type jsonLinesParser struct {
+}
+
+type jsonSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []jsonValue       `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// jsonValue is a sample value, which is either a JSON number or a string for values which aren't valid JSON numbers.
+type jsonValue float64
+
+func (v *jsonValue) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
+			return err
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return errors.New("invalid value %s", b)
+	}
+	*v = jsonValue(f)
+	return nil
+}
+
+func (p *jsonLinesParser) Parse(r *bufio.Reader, handler sampleHandler, reject rejectHandler) error {
+	return readLines(r, func(line int, text string) {
+		var series jsonSeries
+		if err := json.Unmarshal([]byte(text), &series); err != nil {
+			reject(line, text, err)
+			return
+		}
+		if len(series.Values) != len(series.Timestamps) {
+			reject(line, text, errors.New("series has %d values but %d timestamps", len(series.Values), len(series.Timestamps)))
+			return
+		}
+		metric := labels.FromMap(series.Metric)
+		if err := validateMetric(metric); err != nil {
+			reject(line, text, err)
+			return
+		}
+		for i, t := range series.Timestamps {
+			handler(metric, t, float64(series.Values[i]))
+		}
+	})
+}
+
+// csvParser parses CSV according to the mapping.  Rows hold a single sample when the header contains the series or
+// metric column, and otherwise a sample for each of the value columns.  Without configured value columns, every
+// column which isn't the timestamp or a label column is a value column for the series named by its header.
+type csvParser struct {
+	mapping         config.CSVMapping
+	timestampColumn int
+	seriesColumn    int
+	metricColumn    int
+	valueColumn     int
+	valueColumns    map[int]labels.Labels
+	labelColumns    map[int]string
+	series          map[string]labels.Labels
+}
+
+func (p *csvParser) Parse(r *bufio.Reader, handler sampleHandler, reject rejectHandler) error {
+	cr := csv.NewReader(r)
+	cr.Comma, _ = utf8.DecodeRuneInString(p.mapping.Delimiter)
+	header, err := cr.Read()
+	if err != nil {
+		return errors.Wrap(err, "failed to read header")
+	}
+	if err = p.parseHeader(header); err != nil {
+		return err
+	}
+	for {
+		record, errR := cr.Read()
+		if errR == io.EOF {
+			return nil
+		}
+		var parseError *csv.ParseError
+		if stderrors.As(errR, &parseError) {
+			reject(parseError.StartLine, strings.Join(record, p.mapping.Delimiter), parseError.Err)
+			continue
+		} else if errR != nil {
+			return errR
+		}
+		line, _ := cr.FieldPos(0)
+		if errP := p.parseRecord(record, handler); errP != nil {
+			reject(line, strings.Join(record, p.mapping.Delimiter), errP)
+		}
+	}
+}
+
+func (p *csvParser) parseHeader(header []string) error {
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	lookup := func(name string) int {
+		if i, ok := columns[name]; ok && name != "" {
+			return i
+		}
+		return -1
+	}
+	if p.timestampColumn = lookup(p.mapping.TimestampColumn); p.timestampColumn < 0 {
+		return errors.New("header has no timestamp column %q", p.mapping.TimestampColumn)
+	}
+	p.labelColumns = map[int]string{}
+	for column, name := range p.mapping.LabelColumns {
+		i := lookup(column)
+		if i < 0 {
+			return errors.New("header has no label column %q", column)
+		}
+		p.labelColumns[i] = name
+	}
+	p.seriesColumn = lookup(p.mapping.SeriesColumn)
+	p.metricColumn = lookup(p.mapping.MetricColumn)
+	p.valueColumns = map[int]labels.Labels{}
+	if len(p.mapping.ValueColumns) > 0 {
+		for column, series := range p.mapping.ValueColumns {
+			i := lookup(column)
+			if i < 0 {
+				return errors.New("header has no value column %q", column)
+			}
+			metric, err := promParser.ParseMetric(series)
+			if err != nil {
+				return errors.Wrap(err, "invalid series %q for value column %q", series, column)
+			}
+			p.valueColumns[i] = metric
+		}
+		return nil
+	}
+	if p.seriesColumn >= 0 || p.metricColumn >= 0 {
+		if p.valueColumn = lookup(p.mapping.ValueColumn); p.valueColumn < 0 {
+			return errors.New("header has no value column %q", p.mapping.ValueColumn)
+		}
+		return nil
+	}
+	for i, name := range header {
+		if _, ok := p.labelColumns[i]; ok || i == p.timestampColumn {
+			continue
+		}
+		metric, err := promParser.ParseMetric(strings.TrimSpace(name))
+		if err != nil {
+			return errors.Wrap(err, "invalid series %q for column %d", name, i+1)
+		}
+		p.valueColumns[i] = metric
+	}
+	return nil
+}
+
+func (p *csvParser) parseRecord(record []string, handler sampleHandler) error {
+	t, err := p.parseTimestamp(strings.TrimSpace(record[p.timestampColumn]))
+	if err != nil {
+		return err
+	}
+	if len(p.valueColumns) > 0 {
+		type sample struct {
+			metric labels.Labels
+			v      float64
+		}
+		var samples []sample
+		for i, series := range p.valueColumns {
+			text := strings.TrimSpace(record[i])
+			if text == "" {
+				continue
+			}
+			v, errV := strconv.ParseFloat(text, 64)
+			if errV != nil {
+				return errors.New("invalid value %q in column %d", text, i+1)
+			}
+			metric, errM := p.metric(series, record)
+			if errM != nil {
+				return errM
+			}
+			samples = append(samples, sample{metric: metric, v: v})
+		}
+		for _, s := range samples {
+			handler(s.metric, t, s.v)
+		}
+		return nil
+	}
+
+	var series labels.Labels
+	if p.seriesColumn >= 0 {
+		text := strings.TrimSpace(record[p.seriesColumn])
+		var ok bool
+		if series, ok = p.series[text]; !ok {
+			if series, err = promParser.ParseMetric(text); err != nil {
+				return errors.Wrap(err, "invalid series %q", text)
+			}
+			p.series[text] = series
+		}
+	}
+	text := strings.TrimSpace(record[p.valueColumn])
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return errors.New("invalid value %q", text)
+	}
+	metric, err := p.metric(series, record)
+	if err != nil {
+		return err
+	}
+	handler(metric, t, v)
+	return nil
+}
+
+// metric adds the metric name, the label columns and the static labels of the mapping to the series.
+func (p *csvParser) metric(series labels.Labels, record []string) (labels.Labels, error) {
+	builder := labels.NewBuilder(series)
+	if p.metricColumn >= 0 {
+		builder.Set(labels.MetricName, strings.TrimSpace(record[p.metricColumn]))
+	}
+	for i, name := range p.labelColumns {
+		builder.Set(name, strings.TrimSpace(record[i]))
+	}
+	for name, value := range p.mapping.Labels {
+		builder.Set(name, value)
+	}
+	metric := builder.Labels()
+	return metric, validateMetric(metric)
+}
+
+func (p *csvParser) parseTimestamp(text string) (int64, error) {
+	switch p.mapping.TimestampFormat {
+	case "":
+		t, err := common.ParseTime(text)
+		if err != nil {
+			return 0, errors.New("invalid timestamp %q", text)
+		}
+		return t.UnixMilli(), nil
+	case unixTimestampFormat:
+		ts, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) {
+			return 0, errors.New("invalid timestamp %q", text)
+		}
+		return int64(math.Round(ts * 1000)), nil
+	case unixMsTimestampFormat:
+		ts, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return 0, errors.New("invalid timestamp %q", text)
+		}
+		return ts, nil
+	default:
+		t, err := time.Parse(p.mapping.TimestampFormat, text)
+		if err != nil {
+			return 0, errors.New("invalid timestamp %q", text)
+		}
+		return t.UnixMilli(), nil
+	}
+}
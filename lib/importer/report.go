@@ -0,0 +1,56 @@
+package importer
+
+import (
+	"encoding/csv"
+	"github.com/kadaan/promutil/lib/errors"
+	"k8s.io/klog/v2"
+	"os"
+	"strconv"
+)
+
+type rejection struct {
+	file   string
+	line   int
+	reason string
+	text   string
+}
+
+// rejectionReport collects the lines which could not be imported.
+type rejectionReport struct {
+	rejections []rejection
+}
+
+func (r *rejectionReport) reject(file string, line int, text string, reason error) {
+	klog.V(1).Infof("Rejected line %d of %s: %v", line, file, reason)
+	r.rejections = append(r.rejections, rejection{
+		file:   file,
+		line:   line,
+		reason: reason.Error(),
+		text:   text,
+	})
+}
+
+// write writes the rejected lines as CSV to the file, unless the file is empty.
+func (r *rejectionReport) write(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to create error report: %s", filename)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"file", "line", "error", "text"}); err != nil {
+		return errors.Wrap(err, "failed to write error report: %s", filename)
+	}
+	for _, rj := range r.rejections {
+		if err = w.Write([]string{rj.file, strconv.Itoa(rj.line), rj.reason, rj.text}); err != nil {
+			return errors.Wrap(err, "failed to write error report: %s", filename)
+		}
+	}
+	w.Flush()
+	return errors.Wrap(w.Error(), "failed to write error report: %s", filename)
+}
@@ -0,0 +1,120 @@
+package migrator
+
+import (
+	"github.com/kadaan/promutil/lib/remote"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"math"
+	"sort"
+)
+
+const (
+	// initialPenalty is used when the delta between samples is not yet known.  Timestamps are in milliseconds and
+	// sampling intervals are typically several seconds long.
+	initialPenalty = 5000
+)
+
+type replica struct {
+	metric labels.Labels
+	points []promql.Point
+}
+
+type replicaGroup struct {
+	metric   labels.Labels
+	replicas []*replica
+}
+
+// deduplicator merges the series of HA replicas which differ only by the dedup labels.
+type deduplicator struct {
+	labels []string
+	groups map[uint64]*replicaGroup
+	order  []uint64
+}
+
+func newDeduplicator(dedupLabels []string) *deduplicator {
+	return &deduplicator{
+		labels: dedupLabels,
+		groups: map[uint64]*replicaGroup{},
+	}
+}
+
+func (d *deduplicator) Add(metric labels.Labels, points []promql.Point) {
+	key := labels.NewBuilder(metric).Del(d.labels...).Labels()
+	hash := key.Hash()
+	group, ok := d.groups[hash]
+	if !ok {
+		group = &replicaGroup{metric: key}
+		d.groups[hash] = group
+		d.order = append(d.order, hash)
+	}
+	group.replicas = append(group.replicas, &replica{metric: metric, points: points})
+}
+
+// Series calls the handler with each deduplicated series, in the order the series were first added.
+func (d *deduplicator) Series(handler remote.SeriesHandler) error {
+	for _, hash := range d.order {
+		group := d.groups[hash]
+		sort.Slice(group.replicas, func(i, j int) bool {
+			return labels.Compare(group.replicas[i].metric, group.replicas[j].metric) < 0
+		})
+		points := group.replicas[0].points
+		for _, r := range group.replicas[1:] {
+			points = mergeReplicas(points, r.points)
+		}
+		if err := handler(group.metric, remote.NewPointIterator(points)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeReplicas merges the points of two replicas using the penalty based algorithm of Thanos.  Points are taken
+// from one replica until it has a gap, which is then filled from the other replica.  After switching, the replica
+// which was not picked must advance past twice the last sample delta, so the merged series doesn't get a higher
+// sampling frequency than either replica.
+func mergeReplicas(a []promql.Point, b []promql.Point) []promql.Point {
+	merged := make([]promql.Point, 0, len(a))
+	var ia, ib int
+	var penA, penB int64
+	lastT := int64(math.MinInt64)
+	seek := func(points []promql.Point, i int, t int64) int {
+		for i < len(points) && points[i].T < t {
+			i++
+		}
+		return i
+	}
+	for {
+		ia = seek(a, ia, lastT+1+penA)
+		ib = seek(b, ib, lastT+1+penB)
+		aok := ia < len(a)
+		bok := ib < len(b)
+		if !aok && !bok {
+			return merged
+		}
+		if !bok || (aok && a[ia].T <= b[ib].T) {
+			if !bok {
+				penA = 0
+			} else if lastT != math.MinInt64 {
+				penB = 2 * (a[ia].T - lastT)
+				penA = 0
+			} else {
+				penB = initialPenalty
+				penA = 0
+			}
+			lastT = a[ia].T
+			merged = append(merged, a[ia])
+			continue
+		}
+		if !aok {
+			penB = 0
+		} else if lastT != math.MinInt64 {
+			penA = 2 * (b[ib].T - lastT)
+			penB = 0
+		} else {
+			penA = initialPenalty
+			penB = 0
+		}
+		lastT = b[ib].T
+		merged = append(merged, b[ib])
+	}
+}
@@ -0,0 +1,81 @@
+package migrator
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+// replicaPoints returns points at the timestamps, in seconds, with the value identifying the replica.
+func replicaPoints(v float64, timestamps ...int64) []promql.Point {
+	var points []promql.Point
+	for _, ts := range timestamps {
+		points = append(points, promql.Point{T: ts * 1000, V: v})
+	}
+	return points
+}
+
+func TestMergeReplicas(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        []promql.Point
+		b        []promql.Point
+		expected []promql.Point
+	}{
+		{
+			name:     "gap filled from other replica",
+			a:        replicaPoints(1, 0, 15, 30, 105, 120),
+			b:        replicaPoints(2, 2, 17, 32, 47, 62, 77, 92, 107, 122),
+			expected: append(replicaPoints(1, 0, 15, 30), replicaPoints(2, 62, 77, 92, 107, 122)...),
+		},
+		{
+			name:     "gap at start",
+			a:        replicaPoints(1, 60, 75, 90),
+			b:        replicaPoints(2, 2, 17, 32, 47, 62, 77, 92),
+			expected: replicaPoints(2, 2, 17, 32, 47, 62, 77, 92),
+		},
+		{
+			name:     "gap at end",
+			a:        replicaPoints(1, 0, 15, 30),
+			b:        replicaPoints(2, 2, 17, 32, 47, 62, 77, 92),
+			expected: append(replicaPoints(1, 0, 15, 30), replicaPoints(2, 62, 77, 92)...),
+		},
+		{
+			name:     "equal timestamps",
+			a:        replicaPoints(1, 0, 15, 30),
+			b:        replicaPoints(2, 0, 15, 30),
+			expected: replicaPoints(1, 0, 15, 30),
+		},
+		{
+			name:     "empty first replica",
+			a:        nil,
+			b:        replicaPoints(2, 0, 15, 30),
+			expected: replicaPoints(2, 0, 15, 30),
+		},
+		{
+			name:     "empty second replica",
+			a:        replicaPoints(1, 0, 15, 30),
+			b:        nil,
+			expected: replicaPoints(1, 0, 15, 30),
+		},
+		{
+			name:     "both replicas empty",
+			a:        nil,
+			b:        nil,
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := mergeReplicas(test.a, test.b)
+			if len(merged) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, merged)
+			}
+			for i := range merged {
+				if merged[i] != test.expected[i] {
+					t.Fatalf("expected %v, got %v", test.expected, merged)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package migrator
+
+import (
+	"fmt"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"k8s.io/klog/v2"
+	"sort"
+	"strings"
+)
+
+func createPlanData(c *config.MigrateConfig, sources sourceCreator) ([]*planData, error) {
+	var expressions []string
+	for expression := range c.Matchers {
+		expressions = append(expressions, expression)
+	}
+	sort.Strings(expressions)
+
+	var data []*planData
+	for _, expression := range expressions {
+		if c.MaxSeriesPerRequest == 0 {
+			data = append(data, &planData{
+				name:       expression,
+				expression: expression,
+				matchers:   [][]*labels.Matcher{c.Matchers[expression]},
+			})
+			continue
+		}
+		series, err := sources.Series(expression, c.Matchers[expression], c.Start, c.End)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to discover series for '%s'", expression)
+		}
+		klog.V(0).Infof("Discovered %d series for '%s'", len(series), expression)
+		data = append(data, batchSeries(expression, series, int(c.MaxSeriesPerRequest), c.DedupLabels)...)
+	}
+	return data, nil
+}
+
+// maxExpressionLength is the maximum length of the expression used to read a batch of series, so the query_range
+// request stays within the URL length limits of most servers.
+const maxExpressionLength = 8192
+
+// batchSeries splits the series into batches of at most maxSeries series, whose expression is at most
+// maxExpressionLength long.  Replicas of a series, which differ only by the dedup labels, are kept in the same batch so
+// they can be merged.
+func batchSeries(expression string, series []labels.Labels, maxSeries int, dedupLabels []string) []*planData {
+	keys := make([]labels.Labels, len(series))
+	for i, ls := range series {
+		keys[i] = labels.NewBuilder(ls).Del(dedupLabels...).Labels()
+	}
+	sort.Sort(&seriesByKey{series: series, keys: keys})
+
+	names := labelNames(series)
+	matchers := make([][]*labels.Matcher, len(series))
+	selectors := make([]string, len(series))
+	for i, ls := range series {
+		matchers[i] = seriesMatchers(ls, names)
+		selectors[i] = selector(matchers[i])
+	}
+
+	type batch struct {
+		start, end int
+		length     int
+	}
+	var batches []batch
+	var b batch
+	for i := 0; i < len(series); {
+		j := i + 1
+		for j < len(series) && labels.Equal(keys[i], keys[j]) {
+			j++
+		}
+		length := 0
+		for _, s := range selectors[i:j] {
+			length += len(s) + len(" or ")
+		}
+		if b.end > b.start && (b.end-b.start+j-i > maxSeries || b.length+length > maxExpressionLength) {
+			batches = append(batches, b)
+			b = batch{start: i, end: i}
+		}
+		b.end = j
+		b.length += length
+		i = j
+	}
+	if b.end > b.start {
+		batches = append(batches, b)
+	}
+
+	var data []*planData
+	for i, b := range batches {
+		data = append(data, &planData{
+			name:       fmt.Sprintf("%s [batch %d/%d]", expression, i+1, len(batches)),
+			expression: strings.Join(selectors[b.start:b.end], " or "),
+			matchers:   matchers[b.start:b.end],
+		})
+	}
+	return data
+}
+
+type seriesByKey struct {
+	series []labels.Labels
+	keys   []labels.Labels
+}
+
+func (s *seriesByKey) Len() int {
+	return len(s.series)
+}
+
+func (s *seriesByKey) Less(i, j int) bool {
+	if c := labels.Compare(s.keys[i], s.keys[j]); c != 0 {
+		return c < 0
+	}
+	return labels.Compare(s.series[i], s.series[j]) < 0
+}
+
+func (s *seriesByKey) Swap(i, j int) {
+	s.series[i], s.series[j] = s.series[j], s.series[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// labelNames returns the sorted names of the labels of all the series.
+func labelNames(series []labels.Labels) []string {
+	set := map[string]struct{}{}
+	for _, ls := range series {
+		for _, l := range ls {
+			set[l.Name] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// seriesMatchers returns the matchers selecting exactly the series.  The labels in names which the series does not have
+// are matched as empty, so series with additional labels, which are part of other batches, are not selected.
+func seriesMatchers(ls labels.Labels, names []string) []*labels.Matcher {
+	matchers := make([]*labels.Matcher, 0, len(names))
+	for _, name := range names {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, name, ls.Get(name)))
+	}
+	return matchers
+}
+
+func selector(matchers []*labels.Matcher) string {
+	parts := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		parts = append(parts, m.String())
+	}
+	return fmt.Sprintf("{%s}", strings.Join(parts, ", "))
+}
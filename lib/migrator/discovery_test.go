@@ -0,0 +1,102 @@
+package migrator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestBatchSeries(t *testing.T) {
+	// Two series with a value of this length fill an expression up to maxExpressionLength, including the " or " which
+	// is counted for each series.
+	longValue := strings.Repeat("x", (maxExpressionLength-2*len(`{__name__="m", v="a"} or `))/2)
+	tests := []struct {
+		name        string
+		series      []labels.Labels
+		maxSeries   int
+		dedupLabels []string
+		expected    []string
+	}{
+		{
+			name: "exact matchers",
+			series: []labels.Labels{
+				labels.FromStrings(labels.MetricName, "a", "job", "x"),
+				labels.FromStrings(labels.MetricName, "a", "instance", "i", "job", "x"),
+			},
+			maxSeries: 10,
+			expected: []string{
+				`{__name__="a", instance="i", job="x"} or {__name__="a", instance="", job="x"}`,
+			},
+		},
+		{
+			name: "max series",
+			series: []labels.Labels{
+				labels.FromStrings(labels.MetricName, "c"),
+				labels.FromStrings(labels.MetricName, "a"),
+				labels.FromStrings(labels.MetricName, "b"),
+			},
+			maxSeries: 2,
+			expected: []string{
+				`{__name__="a"} or {__name__="b"}`,
+				`{__name__="c"}`,
+			},
+		},
+		{
+			name: "replicas in same batch",
+			series: []labels.Labels{
+				labels.FromStrings(labels.MetricName, "a", "replica", "1"),
+				labels.FromStrings(labels.MetricName, "b", "replica", "1"),
+				labels.FromStrings(labels.MetricName, "a", "replica", "2"),
+			},
+			maxSeries:   1,
+			dedupLabels: []string{"replica"},
+			expected: []string{
+				`{__name__="a", replica="1"} or {__name__="a", replica="2"}`,
+				`{__name__="b", replica="1"}`,
+			},
+		},
+		{
+			name: "expression at length limit",
+			series: []labels.Labels{
+				labels.FromStrings(labels.MetricName, "m", "v", "a"+longValue),
+				labels.FromStrings(labels.MetricName, "m", "v", "b"+longValue),
+			},
+			maxSeries: 10,
+			expected: []string{
+				`{__name__="m", v="a` + longValue + `"} or {__name__="m", v="b` + longValue + `"}`,
+			},
+		},
+		{
+			name: "expression over length limit",
+			series: []labels.Labels{
+				labels.FromStrings(labels.MetricName, "m", "v", "a"+longValue+"x"),
+				labels.FromStrings(labels.MetricName, "m", "v", "b"+longValue),
+			},
+			maxSeries: 10,
+			expected: []string{
+				`{__name__="m", v="a` + longValue + `x"}`,
+				`{__name__="m", v="b` + longValue + `"}`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := batchSeries("test", test.series, test.maxSeries, test.dedupLabels)
+			if len(data) != len(test.expected) {
+				t.Fatalf("expected %d batches, got %d", len(test.expected), len(data))
+			}
+			for i, d := range data {
+				if d.expression != test.expected[i] {
+					t.Errorf("expected expression %d to be %s, got %s", i, test.expected[i], d.expression)
+				}
+				if len(d.expression) > maxExpressionLength {
+					t.Errorf("expected expression %d to be at most %d long, got %d", i, maxExpressionLength, len(d.expression))
+				}
+				if len(d.matchers) != strings.Count(d.expression, " or ")+1 {
+					t.Errorf("expected a matcher set per series of batch %d, got %d", i, len(d.matchers))
+				}
+			}
+		})
+	}
+}
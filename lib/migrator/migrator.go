@@ -2,26 +2,16 @@ package migrator
 
 import (
 	"context"
-	"github.com/cenkalti/backoff"
 	"github.com/kadaan/promutil/config"
 	"github.com/kadaan/promutil/lib/block"
 	"github.com/kadaan/promutil/lib/command"
-	"github.com/kadaan/promutil/lib/common"
 	"github.com/kadaan/promutil/lib/database"
 	"github.com/kadaan/promutil/lib/errors"
-	promConfig "github.com/prometheus/common/config"
-	"github.com/prometheus/common/model"
+	"github.com/kadaan/promutil/lib/remote"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/model/value"
-	"github.com/prometheus/prometheus/prompb"
 	"github.com/prometheus/prometheus/promql"
-	"github.com/prometheus/prometheus/storage"
-	"github.com/prometheus/prometheus/storage/remote"
-	"time"
-)
-
-const (
-	maxQueryRetryAttempts = 5
 )
 
 func NewMigrator() command.Task[config.MigrateConfig] {
@@ -32,107 +22,126 @@ type migrator struct {
 }
 
 func (t *migrator) Run(c *config.MigrateConfig) error {
-	url, err := common.JoinUrl(c.Host, "api/v1/read")
+	sources, err := newSourceCreator(c)
 	if err != nil {
-		return errors.Wrap(err, "failed to create remote read url")
+		return errors.Wrap(err, "failed to create source")
 	}
-	clientConfig := &remote.ClientConfig{
-		URL:              &promConfig.URL{URL: url},
-		Timeout:          model.Duration(2 * time.Minute),
-		HTTPClientConfig: promConfig.HTTPClientConfig{},
-		RetryOnRateLimit: true,
+	defer func(sources sourceCreator) {
+		_ = sources.Close()
+	}(sources)
+
+	data, err := createPlanData(c, sources)
+	if err != nil {
+		return errors.Wrap(err, "failed to plan migration")
 	}
 
-	plannerConfig := block.NewPlannerConfig(c.OutputDirectory, c.Start, c.End, c.SampleInterval, int(c.Parallelism))
-	generator := &planGenerator{matcherSets: c.Matchers}
-	executorCreator := &planExecutorCreator{clientConfig: clientConfig}
-	writer := block.NewPlannedBlockWriter[planData](plannerConfig, generator, executorCreator)
+	plannerConfig := block.NewPlannerConfig(c.OutputDirectory, c.Start, c.End, c.SampleInterval, int(c.Parallelism), block.WriterOptions(c.BlockWriter))
+	generator := &planGenerator{data: data}
+	executorCreator := &planExecutorCreator{
+		sources:        sources,
+		dedupLabels:    c.DedupLabels,
+		relabelConfigs: c.RelabelConfig.RelabelConfigs,
+	}
+	output := block.NewOutput(plannerConfig, c.RemoteWriteConfig)
+	writer := block.NewPlannedBlockWriter[planData](plannerConfig, output, generator, executorCreator)
 	return writer.Run()
 }
 
 type planData struct {
+	name       string
 	expression string
-	matcher    []*labels.Matcher
+	matchers   [][]*labels.Matcher
 }
 
 func (p planData) String() string {
-	return p.expression
+	return p.name
 }
 
 type planGenerator struct {
-	matcherSets map[string][]*labels.Matcher
+	data []*planData
 }
 
 func (p *planGenerator) Generate(chunkStart int64, chunkEnd int64, stepDuration int64) []block.PlanEntry[planData] {
 	var planEntries []block.PlanEntry[planData]
-	for expression, matcher := range p.matcherSets {
-		d := &planData{
-			expression: expression,
-			matcher:    matcher,
-		}
+	for _, d := range p.data {
 		planEntries = append(planEntries, block.NewPlanEntry("migrate", chunkStart, chunkEnd, stepDuration, d))
 	}
 	return planEntries
 }
 
 type planExecutorCreator struct {
-	clientConfig *remote.ClientConfig
+	sources        sourceCreator
+	dedupLabels    []string
+	relabelConfigs []*relabel.Config
 }
 
 func (p *planExecutorCreator) Create(name string, appender database.Appender) (block.PlanExecutor[planData], error) {
-	client, err := remote.NewReadClient(name, p.clientConfig)
+	source, err := p.sources.Create(name)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create remote client")
+		return nil, errors.Wrap(err, "failed to create source")
 	}
 	return &planExecutor{
-		client:   client,
-		appender: appender,
+		source:         source,
+		appender:       appender,
+		dedupLabels:    p.dedupLabels,
+		relabelConfigs: p.relabelConfigs,
 	}, nil
 }
 
 type planExecutor struct {
-	client   remote.ReadClient
-	appender database.Appender
+	source         source
+	appender       database.Appender
+	dedupLabels    []string
+	relabelConfigs []*relabel.Config
 }
 
-func (p *planExecutor) Execute(_ context.Context, logger block.PlanLogger[planData], plan block.PlanEntry[planData]) error {
-	hints := &storage.SelectHints{
-		Start: plan.Start(),
-		End:   plan.End(),
-		Step:  plan.Step(),
-		Range: 0,
-		Func:  "",
-	}
-	query, err := remote.ToQuery(hints.Start, hints.End, plan.Data().matcher, hints)
-	if err != nil {
-		return errors.Wrap(err, "failed to query remote")
+func (p *planExecutor) Execute(ctx context.Context, _ block.PlanLogger[planData], plan block.PlanEntry[planData]) error {
+	if len(p.dedupLabels) == 0 {
+		return p.source.Read(ctx, plan, func(metric labels.Labels, samples remote.SampleIterator) error {
+			return p.appendSeries(plan, metric, samples)
+		})
 	}
 
-	var res *prompb.QueryResult
-	err = backoff.Retry(func() error {
-		r, e := p.client.Read(context.Background(), query)
-		if e != nil {
-			return e
+	dedup := newDeduplicator(p.dedupLabels)
+	err := p.source.Read(ctx, plan, func(metric labels.Labels, samples remote.SampleIterator) error {
+		var points []promql.Point
+		for samples.Next() {
+			t, v := samples.At()
+			if value.IsStaleNaN(v) || t < plan.Start() {
+				continue
+			}
+			points = append(points, promql.Point{T: t, V: v})
 		}
-		res = r
+		if err := samples.Err(); err != nil {
+			return err
+		}
+		dedup.Add(metric, points)
 		return nil
-	}, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), maxQueryRetryAttempts))
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed reading remote data after %d attempts", maxQueryRetryAttempts)
+		return err
 	}
+	return dedup.Series(func(metric labels.Labels, samples remote.SampleIterator) error {
+		return p.appendSeries(plan, metric, samples)
+	})
+}
+
+func (p *planExecutor) appendSeries(plan block.PlanEntry[planData], metric labels.Labels, samples remote.SampleIterator) error {
 	sample := &promql.Sample{}
-	for _, ts := range res.Timeseries {
-		sample.Metric = common.LabelProtosToLabels(ts.Labels)
-		for _, s := range ts.Samples {
-			if value.IsStaleNaN(s.Value) || s.Timestamp < plan.Start() {
-				continue
-			}
-			sample.T = s.Timestamp
-			sample.V = s.Value
-			if err = p.appender.Add(sample); err != nil {
-				return errors.Wrap(err, "failed to add sample: %s", sample)
-			}
+	sample.Metric = relabel.Process(metric, p.relabelConfigs...)
+	if sample.Metric == nil {
+		return nil
+	}
+	for samples.Next() {
+		t, v := samples.At()
+		if value.IsStaleNaN(v) || t < plan.Start() {
+			continue
+		}
+		sample.T = t
+		sample.V = v
+		if err := p.appender.Add(sample); err != nil {
+			return errors.Wrap(err, "failed to add sample: %s", sample)
 		}
 	}
-	return nil
+	return samples.Err()
 }
@@ -0,0 +1,203 @@
+package migrator
+
+import (
+	"context"
+	"github.com/cenkalti/backoff"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/block"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/kadaan/promutil/lib/remote"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"io"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+const (
+	maxQueryRetryAttempts = 5
+)
+
+type source interface {
+	Read(ctx context.Context, plan block.PlanEntry[planData], handler remote.SeriesHandler) error
+}
+
+type sourceCreator interface {
+	io.Closer
+	Create(name string) (source, error)
+	Series(expression string, matchers []*labels.Matcher, start time.Time, end time.Time) ([]labels.Labels, error)
+}
+
+func newSourceCreator(c *config.MigrateConfig) (sourceCreator, error) {
+	if c.SourceDirectory != "" {
+		if filepath.Clean(c.SourceDirectory) == filepath.Clean(c.OutputDirectory) {
+			return nil, errors.New("source directory must differ from output directory")
+		}
+		db, err := database.NewReadOnlyDatabase(c.SourceDirectory)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open source directory")
+		}
+		return &localSourceCreator{db: db}, nil
+	}
+	limiter := remote.NewRateLimiter(c.Host.String(), c.RateLimit.RequestsPerSecond, c.RateLimit.SamplesPerSecond, c.RateLimit.SlowdownLatency)
+	promApi, err := remote.NewAPI(c.Host, c.HTTPConfig, limiter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create api client")
+	}
+	discoverer := &apiSeriesDiscoverer{promApi: promApi}
+	switch c.SourceProtocol {
+	case config.QueryRangeSourceProtocol:
+		queryable, err := remote.NewQueryable(c.Host, c.HTTPConfig, c.Parallelism, limiter)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create queryable")
+		}
+		return &queryRangeSourceCreator{
+			apiSeriesDiscoverer: discoverer,
+			queryable:           queryable,
+		}, nil
+	case config.RemoteReadSourceProtocol:
+		return &remoteReadSourceCreator{
+			apiSeriesDiscoverer: discoverer,
+			host:                c.Host,
+			httpConfig:          c.HTTPConfig,
+			limiter:             limiter,
+		}, nil
+	default:
+		return nil, errors.New("unsupported source protocol: %s", c.SourceProtocol)
+	}
+}
+
+type apiSeriesDiscoverer struct {
+	promApi v1.API
+}
+
+func (d *apiSeriesDiscoverer) Series(expression string, _ []*labels.Matcher, start time.Time, end time.Time) ([]labels.Labels, error) {
+	var series []labels.Labels
+	err := backoff.Retry(func() error {
+		r, _, e := d.promApi.Series(context.Background(), []string{expression}, start, end)
+		if e != nil {
+			return e
+		}
+		series = make([]labels.Labels, 0, len(r))
+		for _, ls := range r {
+			series = append(series, labelSetToLabels(ls))
+		}
+		return nil
+	}, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), maxQueryRetryAttempts))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query series after %d attempts", maxQueryRetryAttempts)
+	}
+	return series, nil
+}
+
+func labelSetToLabels(ls model.LabelSet) labels.Labels {
+	m := make(map[string]string, len(ls))
+	for name, value := range ls {
+		m[string(name)] = string(value)
+	}
+	return labels.FromMap(m)
+}
+
+type remoteReadSourceCreator struct {
+	*apiSeriesDiscoverer
+	host       *url.URL
+	httpConfig promConfig.HTTPClientConfig
+	limiter    remote.RateLimiter
+}
+
+func (s *remoteReadSourceCreator) Create(name string) (source, error) {
+	client, err := remote.NewRemoteReadClient(name, s.host, s.httpConfig, s.limiter)
+	if err != nil {
+		return nil, err
+	}
+	return &matcherSource{source: remote.NewRemoteReadSource(client)}, nil
+}
+
+func (s *remoteReadSourceCreator) Close() error {
+	return nil
+}
+
+// matcherSource reads the series matching the matchers of the plan entries from a remote.Source.
+type matcherSource struct {
+	source remote.Source
+}
+
+func (s *matcherSource) Read(ctx context.Context, plan block.PlanEntry[planData], handler remote.SeriesHandler) error {
+	return s.source.Read(ctx, plan.Start(), plan.End(), plan.Data().matchers, handler)
+}
+
+type queryRangeSourceCreator struct {
+	*apiSeriesDiscoverer
+	queryable remote.Queryable
+}
+
+func (s *queryRangeSourceCreator) Create(_ string) (source, error) {
+	return &queryRangeSource{queryable: s.queryable}, nil
+}
+
+func (s *queryRangeSourceCreator) Close() error {
+	return s.queryable.Close()
+}
+
+type queryRangeSource struct {
+	queryable remote.Queryable
+}
+
+func (s *queryRangeSource) Read(ctx context.Context, plan block.PlanEntry[planData], handler remote.SeriesHandler) error {
+	start := time.UnixMilli(plan.Start()).UTC()
+	end := time.UnixMilli(plan.End()).UTC()
+	step := time.Duration(plan.Step()) * time.Millisecond
+	provider, err := s.queryable.QueryFuncProvider(start, end, step)
+	if err != nil {
+		return errors.Wrap(err, "failed to create query func provider")
+	}
+	matrix, err := provider.RangeQueryFunc()(ctx, plan.Data().expression, start, end, step)
+	if err != nil {
+		return errors.Wrap(err, "failed to query range")
+	}
+	for _, series := range matrix {
+		if err = handler(series.Metric, remote.NewPointIterator(series.Points)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type localSourceCreator struct {
+	db database.ReadOnlyDatabase
+}
+
+// Create creates a source sharing the database of the creator, which closes it.
+func (s *localSourceCreator) Create(_ string) (source, error) {
+	return &matcherSource{source: remote.NewLocalSource(s.db)}, nil
+}
+
+func (s *localSourceCreator) Series(_ string, matchers []*labels.Matcher, start time.Time, end time.Time) ([]labels.Labels, error) {
+	q, err := s.db.Querier(start.UnixMilli(), end.UnixMilli())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create querier")
+	}
+	defer func(q storage.Querier) {
+		_ = q.Close()
+	}(q)
+	hints := &storage.SelectHints{
+		Start: start.UnixMilli(),
+		End:   end.UnixMilli(),
+		Func:  "series",
+	}
+	var series []labels.Labels
+	ss := q.Select(false, hints, matchers...)
+	for ss.Next() {
+		series = append(series, ss.At().Labels())
+	}
+	return series, errors.Wrap(ss.Err(), "failed to select series")
+}
+
+func (s *localSourceCreator) Close() error {
+	return s.db.Close()
+}
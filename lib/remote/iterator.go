@@ -0,0 +1,106 @@
+package remote
+
+import (
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+type SampleIterator interface {
+	Next() bool
+	At() (int64, float64)
+	Err() error
+}
+
+func NewPointIterator(points []promql.Point) SampleIterator {
+	return &pointIterator{
+		points: points,
+		idx:    -1,
+	}
+}
+
+type pointIterator struct {
+	points []promql.Point
+	idx    int
+}
+
+func (it *pointIterator) Next() bool {
+	it.idx++
+	return it.idx < len(it.points)
+}
+
+func (it *pointIterator) At() (int64, float64) {
+	p := it.points[it.idx]
+	return p.T, p.V
+}
+
+func (it *pointIterator) Err() error {
+	return nil
+}
+
+// chunkedSeriesIterator walks the XOR chunks of a series in order, skipping
+// samples outside the queried range as well as samples from overlapping chunks
+// that have already been returned.
+type chunkedSeriesIterator struct {
+	chunks []prompb.Chunk
+	mint   int64
+	maxt   int64
+	idx    int
+	cur    chunkenc.Iterator
+	lastT  int64
+	t      int64
+	v      float64
+	err    error
+}
+
+func (it *chunkedSeriesIterator) Next() bool {
+	for {
+		if it.cur == nil || !it.cur.Next() {
+			if it.cur != nil && it.cur.Err() != nil {
+				it.err = it.cur.Err()
+				return false
+			}
+			if !it.nextChunk() {
+				return false
+			}
+			continue
+		}
+		t, v := it.cur.At()
+		if t <= it.lastT || t > it.maxt {
+			continue
+		}
+		it.t, it.v, it.lastT = t, v, t
+		return true
+	}
+}
+
+func (it *chunkedSeriesIterator) nextChunk() bool {
+	for it.idx+1 < len(it.chunks) {
+		it.idx++
+		c := it.chunks[it.idx]
+		if c.MaxTimeMs < it.mint || c.MinTimeMs > it.maxt {
+			continue
+		}
+		if c.Type != prompb.Chunk_XOR {
+			it.err = errors.New("unsupported chunk encoding: %s", c.Type)
+			return false
+		}
+		chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+		if err != nil {
+			it.err = errors.Wrap(err, "failed to decode chunk")
+			return false
+		}
+		it.cur = chk.Iterator(nil)
+		return true
+	}
+	return false
+}
+
+func (it *chunkedSeriesIterator) At() (int64, float64) {
+	return it.t, it.v
+}
+
+func (it *chunkedSeriesIterator) Err() error {
+	return it.err
+}
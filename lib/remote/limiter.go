@@ -0,0 +1,244 @@
+package remote
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minSlowdownDelay   = 250 * time.Millisecond
+	maxSlowdownDelay   = time.Minute
+	maxSampleWaitBatch = 1000
+)
+
+// RateLimiter limits the requests and samples read from a single remote endpoint.  It is shared by every
+// client of the endpoint, so the limits apply to the endpoint as a whole regardless of parallelism.
+type RateLimiter interface {
+	RoundTripper(next http.RoundTripper) http.RoundTripper
+	WaitSamples(ctx context.Context, count int) error
+	Iterator(ctx context.Context, it SampleIterator) SampleIterator
+}
+
+// NewRateLimiter creates a RateLimiter for the endpoint.  A requestsPerSecond or samplesPerSecond of zero
+// disables the corresponding limit.  Requests are additionally spaced out when the endpoint responds with
+// 429 or 503, or takes longer than latencyThreshold to respond when latencyThreshold is non-zero.
+func NewRateLimiter(endpoint string, requestsPerSecond float64, samplesPerSecond float64, latencyThreshold time.Duration) RateLimiter {
+	requests := rate.NewLimiter(rate.Inf, 1)
+	if requestsPerSecond > 0 {
+		requests = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+	}
+	samples := rate.NewLimiter(rate.Inf, 1)
+	sampleBurst := maxSampleWaitBatch
+	if samplesPerSecond > 0 {
+		sampleBurst = int(samplesPerSecond)
+		if sampleBurst < 1 {
+			sampleBurst = 1
+		}
+		samples = rate.NewLimiter(rate.Limit(samplesPerSecond), sampleBurst)
+	}
+	return &rateLimiter{
+		endpoint:         endpoint,
+		requests:         requests,
+		samples:          samples,
+		sampleBurst:      sampleBurst,
+		latencyThreshold: latencyThreshold,
+	}
+}
+
+type rateLimiter struct {
+	endpoint         string
+	requests         *rate.Limiter
+	samples          *rate.Limiter
+	sampleBurst      int
+	latencyThreshold time.Duration
+	mtx              sync.Mutex
+	delay            time.Duration
+	next             time.Time
+}
+
+func (l *rateLimiter) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return &rateLimitedRoundTripper{
+		limiter: l,
+		next:    next,
+	}
+}
+
+func (l *rateLimiter) WaitSamples(ctx context.Context, count int) error {
+	for count > 0 {
+		n := count
+		if n > l.sampleBurst {
+			n = l.sampleBurst
+		}
+		if err := l.samples.WaitN(ctx, n); err != nil {
+			return err
+		}
+		count -= n
+	}
+	return nil
+}
+
+func (l *rateLimiter) Iterator(ctx context.Context, it SampleIterator) SampleIterator {
+	batch := l.sampleBurst
+	if batch > maxSampleWaitBatch {
+		batch = maxSampleWaitBatch
+	}
+	return &rateLimitedIterator{
+		ctx:     ctx,
+		limiter: l,
+		it:      it,
+		batch:   batch,
+	}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if err := l.requests.Wait(ctx); err != nil {
+		return err
+	}
+	l.mtx.Lock()
+	var wait time.Duration
+	if l.delay > 0 {
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait = l.next.Sub(now)
+		l.next = l.next.Add(l.delay)
+	}
+	l.mtx.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l *rateLimiter) observe(resp *http.Response, latency time.Duration) {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		l.slowDown(retryAfter(resp), resp.Status)
+	} else if l.latencyThreshold > 0 && latency > l.latencyThreshold {
+		l.slowDown(0, "latency "+latency.String())
+	} else {
+		l.speedUp()
+	}
+}
+
+func (l *rateLimiter) slowDown(minimum time.Duration, reason string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delay := l.delay * 2
+	if delay < minSlowdownDelay {
+		delay = minSlowdownDelay
+	}
+	if delay < minimum {
+		delay = minimum
+	}
+	if delay > maxSlowdownDelay {
+		delay = maxSlowdownDelay
+	}
+	if delay != l.delay {
+		klog.V(0).Infof("Slowing down requests to %s to one every %s (%s)", l.endpoint, delay, reason)
+	}
+	l.delay = delay
+}
+
+func (l *rateLimiter) speedUp() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.delay == 0 {
+		return
+	}
+	l.delay = l.delay / 2
+	if l.delay < minSlowdownDelay {
+		l.delay = 0
+		klog.V(0).Infof("Resuming full request rate to %s", l.endpoint)
+	}
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+type rateLimitedRoundTripper struct {
+	limiter *rateLimiter
+	next    http.RoundTripper
+}
+
+func (rt *rateLimitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := rt.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	if err == nil {
+		rt.limiter.observe(resp, time.Since(start))
+	}
+	return resp, err
+}
+
+type rateLimitedIterator struct {
+	ctx     context.Context
+	limiter *rateLimiter
+	it      SampleIterator
+	batch   int
+	count   int
+	err     error
+}
+
+// Next waits for the samples of a batch after they are consumed, before advancing past the batch, and for the samples
+// of a partial batch when the underlying iterator is exhausted.  The first batch is therefore handed out without
+// waiting, which stays within the burst of the limiter.  The failure of waiting is reported by Err.
+func (i *rateLimitedIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	if i.count >= i.batch {
+		if i.flush(); i.err != nil {
+			return false
+		}
+	}
+	if !i.it.Next() {
+		i.flush()
+		return false
+	}
+	i.count++
+	return true
+}
+
+func (i *rateLimitedIterator) flush() {
+	if i.count > 0 {
+		i.err = i.limiter.WaitSamples(i.ctx, i.count)
+		i.count = 0
+	}
+}
+
+func (i *rateLimitedIterator) At() (int64, float64) {
+	return i.it.At()
+}
+
+func (i *rateLimitedIterator) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.it.Err()
+}
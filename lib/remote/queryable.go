@@ -10,6 +10,7 @@ import (
 	"github.com/kadaan/tracerr"
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	promConfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
@@ -26,22 +27,35 @@ const (
 	maxChunkDuration = 30 * time.Minute
 )
 
-func NewQueryable(address *url.URL, parallelism uint8) (Queryable, error) {
+func NewAPI(address *url.URL, httpConfig promConfig.HTTPClientConfig, limiter RateLimiter) (v1.API, error) {
+	roundTripper, err := promConfig.NewRoundTripperFromConfig(httpConfig, "promutil_api")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create api round tripper")
+	}
 	client, err := api.NewClient(api.Config{
-		Address: address.String(),
+		Address:      address.String(),
+		RoundTripper: limiter.RoundTripper(roundTripper),
 	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create api client")
+	}
+	return v1.NewAPI(client), nil
+}
+
+func NewQueryable(address *url.URL, httpConfig promConfig.HTTPClientConfig, parallelism uint8, limiter RateLimiter) (Queryable, error) {
+	promApi, err := NewAPI(address, httpConfig, limiter)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create queryable provider")
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	var cg sync.WaitGroup
-	promApi := v1.NewAPI(client)
 	inputChan := make(chan plan)
 	for i := uint8(0); i < common.MaxUInt8(parallelism, uint8(1)); i++ {
 		qr := querier{
 			ctx:      ctx,
 			cg:       &cg,
 			promApi:  promApi,
+			limiter:  limiter,
 			input:    inputChan,
 			stopOnce: &sync.Once{},
 		}
@@ -225,6 +239,7 @@ type querier struct {
 	ctx      context.Context
 	cg       *sync.WaitGroup
 	promApi  v1.API
+	limiter  RateLimiter
 	input    <-chan plan
 	stopOnce *sync.Once
 }
@@ -277,6 +292,17 @@ func (q *querier) execute(p plan) {
 
 	switch v := (*value).(type) {
 	case model.Matrix:
+		var samples int
+		for _, ss := range v {
+			samples += len(ss.Values)
+		}
+		if err = q.limiter.WaitSamples(p.ctx, samples); err != nil {
+			p.output <- result{
+				wg:  p.wg,
+				err: errors.Wrap(err, "failed to wait for sample rate limit"),
+			}
+			return
+		}
 		var series []*promql.Series
 		for _, ss := range v {
 			var points []promql.Point
@@ -0,0 +1,259 @@
+package remote
+
+import (
+	"context"
+	"github.com/cenkalti/backoff"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	prometheusConfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+	promRemote "github.com/prometheus/prometheus/storage/remote"
+	"k8s.io/klog/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxWriteRetryAttempts = 10
+)
+
+// NewWriteManager creates an append manager which sends samples to a remote write endpoint.  Samples are
+// distributed across max_shards shards by series, so the samples of a series are sent in the order they are
+// appended.  Receivers reject out-of-order samples, so the samples of a series must be appended in time order by a
+// single appender.
+func NewWriteManager(cfg *prometheusConfig.RemoteWriteConfig) (database.AppendManager, error) {
+	client, err := NewWriteClient("promutil_remote_write", &promRemote.ClientConfig{
+		URL:              cfg.URL,
+		Timeout:          cfg.RemoteTimeout,
+		HTTPClientConfig: cfg.HTTPClientConfig,
+		Headers:          cfg.Headers,
+		RetryOnRateLimit: cfg.QueueConfig.RetryOnRateLimit,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create remote write client")
+	}
+	queueConfig := cfg.QueueConfig
+	if queueConfig.MaxShards < 1 {
+		queueConfig.MaxShards = 1
+	}
+	if queueConfig.MaxSamplesPerSend < 1 {
+		queueConfig.MaxSamplesPerSend = prometheusConfig.DefaultQueueConfig.MaxSamplesPerSend
+	}
+	if queueConfig.Capacity < queueConfig.MaxSamplesPerSend {
+		queueConfig.Capacity = queueConfig.MaxSamplesPerSend
+	}
+	if queueConfig.BatchSendDeadline <= 0 {
+		queueConfig.BatchSendDeadline = prometheusConfig.DefaultQueueConfig.BatchSendDeadline
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &writeManager{
+		url:            cfg.URL.String(),
+		client:         client,
+		queueConfig:    queueConfig,
+		relabelConfigs: cfg.WriteRelabelConfigs,
+		ctx:            ctx,
+		cancel:         cancel,
+		stats:          database.NewAppendStats(),
+	}
+	for i := 0; i < queueConfig.MaxShards; i++ {
+		s := &writeShard{
+			manager: m,
+			queue:   make(chan writeSample, queueConfig.Capacity),
+		}
+		m.shards = append(m.shards, s)
+		m.wg.Add(1)
+		go s.run()
+	}
+	return m, nil
+}
+
+type writeSample struct {
+	metric labels.Labels
+	t      int64
+	v      float64
+}
+
+type writeManager struct {
+	url            string
+	client         WriteClient
+	queueConfig    prometheusConfig.QueueConfig
+	relabelConfigs []*relabel.Config
+	ctx            context.Context
+	cancel         context.CancelFunc
+	shards         []*writeShard
+	wg             sync.WaitGroup
+	mtx            sync.Mutex
+	err            error
+	stats          *database.AppendStats
+	stopped        bool
+	samples        uint64
+	requests       uint64
+}
+
+func (m *writeManager) NewAppender() (database.Appender, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.stopped {
+		return nil, errors.New("cannot create an appender for a closed remote write queue")
+	}
+	return &writeAppender{manager: m}, nil
+}
+
+func (m *writeManager) Stats() *database.AppendStats {
+	return m.stats
+}
+
+func (m *writeManager) Close() error {
+	m.mtx.Lock()
+	if m.stopped {
+		m.mtx.Unlock()
+		return nil
+	}
+	m.stopped = true
+	m.mtx.Unlock()
+
+	for _, s := range m.shards {
+		close(s.queue)
+	}
+	m.wg.Wait()
+	m.cancel()
+	klog.V(0).Infof("Sent %d samples in %d requests to %s", atomic.LoadUint64(&m.samples), atomic.LoadUint64(&m.requests), m.url)
+	return m.err
+}
+
+func (m *writeManager) enqueue(sample writeSample) error {
+	s := m.shards[sample.metric.Hash()%uint64(len(m.shards))]
+	select {
+	case <-m.ctx.Done():
+		return m.failure()
+	case s.queue <- sample:
+		return nil
+	}
+}
+
+func (m *writeManager) fail(err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.cancel()
+}
+
+func (m *writeManager) failure() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.err == nil {
+		return errors.New("remote write queue is closed")
+	}
+	return m.err
+}
+
+func (m *writeManager) send(series []prompb.TimeSeries, samples int) error {
+	req := &prompb.WriteRequest{Timeseries: series}
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = time.Duration(m.queueConfig.MinBackoff)
+	b.MaxInterval = time.Duration(m.queueConfig.MaxBackoff)
+	b.MaxElapsedTime = 0
+	err := backoff.Retry(func() error {
+		if e := m.client.Write(m.ctx, req); e != nil {
+			if _, ok := e.(RecoverableError); ok {
+				klog.V(1).Infof("Retrying remote write to %s: %v", m.url, e)
+				return e
+			}
+			return backoff.Permanent(e)
+		}
+		return nil
+	}, backoff.WithMaxRetries(b, maxWriteRetryAttempts))
+	if err != nil {
+		return errors.Wrap(err, "failed to send %d samples to %s", samples, m.url)
+	}
+	atomic.AddUint64(&m.samples, uint64(samples))
+	atomic.AddUint64(&m.requests, 1)
+	m.stats.AddWritten(uint64(req.Size()))
+	return nil
+}
+
+type writeShard struct {
+	manager *writeManager
+	queue   chan writeSample
+}
+
+func (s *writeShard) run() {
+	defer s.manager.wg.Done()
+	deadline := time.Duration(s.manager.queueConfig.BatchSendDeadline)
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	pending := make([]writeSample, 0, s.manager.queueConfig.MaxSamplesPerSend)
+	for {
+		select {
+		case sample, ok := <-s.queue:
+			if !ok {
+				s.flush(pending)
+				return
+			}
+			pending = append(pending, sample)
+			if len(pending) >= s.manager.queueConfig.MaxSamplesPerSend {
+				s.flush(pending)
+				pending = pending[:0]
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(deadline)
+			}
+		case <-timer.C:
+			s.flush(pending)
+			pending = pending[:0]
+			timer.Reset(deadline)
+		}
+	}
+}
+
+func (s *writeShard) flush(pending []writeSample) {
+	if len(pending) == 0 || s.manager.ctx.Err() != nil {
+		return
+	}
+	var series []prompb.TimeSeries
+	index := make(map[uint64]int)
+	for _, sample := range pending {
+		hash := sample.metric.Hash()
+		i, ok := index[hash]
+		if !ok {
+			i = len(series)
+			index[hash] = i
+			series = append(series, prompb.TimeSeries{Labels: common.LabelsToLabelProtos(sample.metric)})
+		}
+		series[i].Samples = append(series[i].Samples, prompb.Sample{Timestamp: sample.t, Value: sample.v})
+	}
+	if err := s.manager.send(series, len(pending)); err != nil {
+		s.manager.fail(err)
+	}
+}
+
+type writeAppender struct {
+	manager *writeManager
+}
+
+func (a *writeAppender) Add(sample *promql.Sample) error {
+	metric := sample.Metric
+	if len(a.manager.relabelConfigs) > 0 {
+		metric = relabel.Process(metric, a.manager.relabelConfigs...)
+		if metric == nil {
+			return nil
+		}
+	}
+	if err := a.manager.enqueue(writeSample{metric: metric, t: sample.T, v: sample.V}); err != nil {
+		return err
+	}
+	a.manager.stats.AddAppended()
+	return nil
+}
+
+func (a *writeAppender) Close() error {
+	return nil
+}
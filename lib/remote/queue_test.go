@@ -0,0 +1,172 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	prometheusConfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// writeReceiver decodes remote write requests, and rejects samples older than the latest sample of their series like
+// Prometheus does.
+type writeReceiver struct {
+	mtx     sync.Mutex
+	samples map[string][]prompb.Sample
+}
+
+func (r *writeReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Encoding") != "snappy" {
+		http.Error(w, "unexpected content encoding", http.StatusBadRequest)
+		return
+	}
+	if req.Header.Get(remoteWriteVersionHeader) != remoteWriteVersion {
+		http.Error(w, "unexpected remote write version", http.StatusBadRequest)
+		return
+	}
+	compressed, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var writeRequest prompb.WriteRequest
+	if err = proto.Unmarshal(data, &writeRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, ts := range writeRequest.Timeseries {
+		key := labelProtosToLabels(ts.Labels).String()
+		for _, s := range ts.Samples {
+			existing := r.samples[key]
+			if len(existing) > 0 && s.Timestamp <= existing[len(existing)-1].Timestamp {
+				http.Error(w, fmt.Sprintf("out of order sample for %s", key), http.StatusBadRequest)
+				return
+			}
+			r.samples[key] = append(existing, s)
+		}
+	}
+}
+
+func labelProtosToLabels(labelPairs []prompb.Label) labels.Labels {
+	b := labels.NewBuilder(nil)
+	for _, l := range labelPairs {
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
+
+func newTestWriteConfig(t *testing.T, server *httptest.Server) *prometheusConfig.RemoteWriteConfig {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queueConfig := prometheusConfig.DefaultQueueConfig
+	queueConfig.MaxShards = 4
+	queueConfig.MaxSamplesPerSend = 7
+	queueConfig.BatchSendDeadline = model.Duration(10 * time.Millisecond)
+	queueConfig.MinBackoff = model.Duration(time.Millisecond)
+	queueConfig.MaxBackoff = model.Duration(time.Millisecond)
+	return &prometheusConfig.RemoteWriteConfig{
+		URL:              &promConfig.URL{URL: u},
+		RemoteTimeout:    model.Duration(time.Second),
+		HTTPClientConfig: promConfig.DefaultHTTPClientConfig,
+		QueueConfig:      queueConfig,
+	}
+}
+
+func TestWriteManager(t *testing.T) {
+	receiver := &writeReceiver{samples: map[string][]prompb.Sample{}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	manager, err := NewWriteManager(newTestWriteConfig(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appender, err := manager.NewAppender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const seriesCount = 10
+	const samplesPerSeries = 100
+	for ts := int64(0); ts < samplesPerSeries; ts++ {
+		for i := 0; i < seriesCount; i++ {
+			sample := &promql.Sample{
+				Metric: labels.FromStrings(labels.MetricName, "test_metric", "series", fmt.Sprint(i)),
+				Point:  promql.Point{T: ts * 1000, V: float64(ts)},
+			}
+			if err = appender.Add(sample); err != nil {
+				t.Fatalf("failed to add sample: %v", err)
+			}
+		}
+	}
+	if err = appender.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.Close(); err != nil {
+		t.Fatalf("failed to send samples: %v", err)
+	}
+
+	if got := manager.Stats().Appended(); got != seriesCount*samplesPerSeries {
+		t.Errorf("expected %d appended samples, got %d", seriesCount*samplesPerSeries, got)
+	}
+	if len(receiver.samples) != seriesCount {
+		t.Fatalf("expected %d series, got %d", seriesCount, len(receiver.samples))
+	}
+	for key, samples := range receiver.samples {
+		if len(samples) != samplesPerSeries {
+			t.Errorf("expected %d samples for %s, got %d", samplesPerSeries, key, len(samples))
+			continue
+		}
+		for i, s := range samples {
+			if s.Timestamp != int64(i)*1000 || s.Value != float64(i) {
+				t.Errorf("unexpected sample %d for %s: %v", i, key, s)
+				break
+			}
+		}
+	}
+}
+
+func TestWriteManagerOutOfOrder(t *testing.T) {
+	receiver := &writeReceiver{samples: map[string][]prompb.Sample{}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	manager, err := NewWriteManager(newTestWriteConfig(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appender, err := manager.NewAppender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metric := labels.FromStrings(labels.MetricName, "test_metric")
+	for _, ts := range []int64{2000, 1000} {
+		if err = appender.Add(&promql.Sample{Metric: metric, Point: promql.Point{T: ts, V: 1}}); err != nil {
+			t.Fatalf("failed to add sample: %v", err)
+		}
+	}
+	if err = manager.Close(); err == nil {
+		t.Fatal("expected out of order samples to be rejected")
+	}
+}
@@ -0,0 +1,190 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/errors"
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	promRemote "github.com/prometheus/prometheus/storage/remote"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	chunkedReadLimit        = 50 * 1024 * 1024
+	maxErrorMessageLength   = 1024
+	streamedContentType     = "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse"
+	remoteReadVersionHeader = "X-Prometheus-Remote-Read-Version"
+	remoteReadVersion       = "0.1.0"
+	userAgent               = "promutil"
+)
+
+type SeriesHandler func(metric labels.Labels, samples SampleIterator) error
+
+type ReadClient interface {
+	Read(ctx context.Context, queries []*prompb.Query, handler SeriesHandler) error
+}
+
+func NewReadClient(name string, cfg *promRemote.ClientConfig, limiter RateLimiter) (ReadClient, error) {
+	httpClient, err := promConfig.NewClientFromConfig(cfg.HTTPClientConfig, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create http client")
+	}
+	httpClient.Transport = limiter.RoundTripper(httpClient.Transport)
+	return &readClient{
+		url:     cfg.URL.String(),
+		client:  httpClient,
+		limiter: limiter,
+		timeout: time.Duration(cfg.Timeout),
+	}, nil
+}
+
+type readClient struct {
+	url     string
+	client  *http.Client
+	limiter RateLimiter
+	timeout time.Duration
+}
+
+func (c *readClient) Read(ctx context.Context, queries []*prompb.Query, handler SeriesHandler) error {
+	req := &prompb.ReadRequest{
+		Queries: queries,
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{
+			prompb.ReadRequest_STREAMED_XOR_CHUNKS,
+			prompb.ReadRequest_SAMPLES,
+		},
+	}
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal read request")
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return errors.Wrap(err, "failed to create read request")
+	}
+	httpReq.Header.Add("Content-Encoding", "snappy")
+	httpReq.Header.Add("Accept-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", userAgent)
+	httpReq.Header.Set(remoteReadVersionHeader, remoteReadVersion)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	httpResp, err := c.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to send read request")
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, httpResp.Body)
+		_ = httpResp.Body.Close()
+	}()
+
+	if httpResp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(httpResp.Body, maxErrorMessageLength))
+		return errors.New("remote server %s returned HTTP status %s: %s", c.url, httpResp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if strings.HasPrefix(httpResp.Header.Get("Content-Type"), streamedContentType) {
+		return c.readStreamed(ctx, httpResp.Body, queries, handler)
+	}
+	return c.readSampled(ctx, httpResp.Body, queries, handler)
+}
+
+// readStreamed calls the handler once per series.  Prometheus splits a series with many chunks across consecutive
+// frames with the same labels, so the chunks of consecutive frames are merged until the labels or query change.
+func (c *readClient) readStreamed(ctx context.Context, body io.Reader, queries []*prompb.Query, handler SeriesHandler) error {
+	reader := promRemote.NewChunkedReader(body, chunkedReadLimit, nil)
+	var pending *prompb.ChunkedSeries
+	var pendingQuery *prompb.Query
+	flush := func() error {
+		if pending == nil {
+			return nil
+		}
+		it := &chunkedSeriesIterator{
+			chunks: pending.Chunks,
+			mint:   pendingQuery.StartTimestampMs,
+			maxt:   pendingQuery.EndTimestampMs,
+			idx:    -1,
+			lastT:  pendingQuery.StartTimestampMs - 1,
+		}
+		metric := common.LabelProtosToLabels(pending.Labels)
+		pending = nil
+		return handler(metric, c.limiter.Iterator(ctx, it))
+	}
+	for {
+		res := &prompb.ChunkedReadResponse{}
+		err := reader.NextProto(res)
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read chunked response")
+		}
+		if res.QueryIndex < 0 || res.QueryIndex >= int64(len(queries)) {
+			return errors.New("chunked response references unknown query %d", res.QueryIndex)
+		}
+		query := queries[res.QueryIndex]
+		for _, cs := range res.ChunkedSeries {
+			if pending != nil && pendingQuery == query && labelProtosEqual(pending.Labels, cs.Labels) {
+				pending.Chunks = append(pending.Chunks, cs.Chunks...)
+				continue
+			}
+			if err = flush(); err != nil {
+				return err
+			}
+			pending, pendingQuery = cs, query
+		}
+	}
+}
+
+func labelProtosEqual(a []prompb.Label, b []prompb.Label) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *readClient) readSampled(ctx context.Context, body io.Reader, queries []*prompb.Query, handler SeriesHandler) error {
+	compressed, err := io.ReadAll(body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read sampled response")
+	}
+	uncompressed, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress sampled response")
+	}
+	var resp prompb.ReadResponse
+	if err = proto.Unmarshal(uncompressed, &resp); err != nil {
+		return errors.Wrap(err, "failed to unmarshal sampled response")
+	}
+	if len(resp.Results) != len(queries) {
+		return errors.New("expected %d query results, got %d", len(queries), len(resp.Results))
+	}
+	for _, result := range resp.Results {
+		ss := promRemote.FromQueryResult(false, result)
+		for ss.Next() {
+			series := ss.At()
+			if err = handler(series.Labels(), c.limiter.Iterator(ctx, series.Iterator())); err != nil {
+				return err
+			}
+		}
+		if err = ss.Err(); err != nil {
+			return errors.Wrap(err, "failed to iterate sampled response")
+		}
+	}
+	return nil
+}
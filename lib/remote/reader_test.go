@@ -0,0 +1,104 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	promRemote "github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// streamedReadServer writes each of the frames as a chunked read response.
+func streamedReadServer(t *testing.T, frames []*prompb.ChunkedReadResponse) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", streamedContentType)
+		writer := promRemote.NewChunkedWriter(w, w.(http.Flusher))
+		for _, frame := range frames {
+			data, err := proto.Marshal(frame)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err = writer.Write(data); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}))
+}
+
+func newTestChunk(t *testing.T, mint int64, maxt int64) prompb.Chunk {
+	chunk := chunkenc.NewXORChunk()
+	appender, err := chunk.Appender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts := mint; ts <= maxt; ts += 1000 {
+		appender.Append(ts, float64(ts/1000))
+	}
+	return prompb.Chunk{MinTimeMs: mint, MaxTimeMs: maxt, Type: prompb.Chunk_XOR, Data: chunk.Bytes()}
+}
+
+func TestReadStreamedSplitSeries(t *testing.T) {
+	split := []prompb.Label{{Name: labels.MetricName, Value: "split"}}
+	other := []prompb.Label{{Name: labels.MetricName, Value: "other"}}
+	server := streamedReadServer(t, []*prompb.ChunkedReadResponse{
+		{ChunkedSeries: []*prompb.ChunkedSeries{{Labels: split, Chunks: []prompb.Chunk{newTestChunk(t, 0, 9000)}}}},
+		{ChunkedSeries: []*prompb.ChunkedSeries{
+			{Labels: split, Chunks: []prompb.Chunk{newTestChunk(t, 10000, 19000)}},
+			{Labels: other, Chunks: []prompb.Chunk{newTestChunk(t, 0, 4000)}},
+		}},
+	})
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewReadClient("test", &promRemote.ClientConfig{
+		URL:              &promConfig.URL{URL: u},
+		Timeout:          model.Duration(time.Second),
+		HTTPClientConfig: promConfig.DefaultHTTPClientConfig,
+	}, NewRateLimiter("test", 0, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics []string
+	samples := map[string][]int64{}
+	query := &prompb.Query{StartTimestampMs: 0, EndTimestampMs: 19000}
+	err = client.Read(context.Background(), []*prompb.Query{query}, func(metric labels.Labels, it SampleIterator) error {
+		metrics = append(metrics, metric.Get(labels.MetricName))
+		for it.Next() {
+			ts, _ := it.At()
+			samples[metric.Get(labels.MetricName)] = append(samples[metric.Get(labels.MetricName)], ts)
+		}
+		return it.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 || metrics[0] != "split" || metrics[1] != "other" {
+		t.Fatalf("expected the handler to be called for split and other, got %v", metrics)
+	}
+	if len(samples["split"]) != 20 {
+		t.Fatalf("expected 20 samples for split, got %d", len(samples["split"]))
+	}
+	for i, ts := range samples["split"] {
+		if ts != int64(i)*1000 {
+			t.Errorf("unexpected timestamp %d for sample %d of split", ts, i)
+		}
+	}
+	if len(samples["other"]) != 5 {
+		t.Errorf("expected 5 samples for other, got %d", len(samples["other"]))
+	}
+}
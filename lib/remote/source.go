@@ -0,0 +1,148 @@
+package remote
+
+import (
+	"context"
+	"github.com/cenkalti/backoff"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	promRemote "github.com/prometheus/prometheus/storage/remote"
+	"io"
+	"net/url"
+	"time"
+)
+
+const (
+	maxReadRetryAttempts = 5
+	remoteReadTimeout    = 2 * time.Minute
+)
+
+// Source reads the series of a local TSDB directory, or of a remote host using remote read.
+type Source interface {
+	io.Closer
+	// Read passes the series matching any of the matcher sets from start to end to the handler.
+	Read(ctx context.Context, start int64, end int64, matcherSets [][]*labels.Matcher, handler SeriesHandler) error
+}
+
+// NewSource creates a source reading the TSDB directory when it is set, and the host otherwise.  The requests to the host
+// are rate limited as described by NewRateLimiter.
+func NewSource(name string, directory string, host *url.URL, httpConfig promConfig.HTTPClientConfig, requestsPerSecond float64, samplesPerSecond float64, latencyThreshold time.Duration) (Source, error) {
+	if directory != "" {
+		db, err := database.NewReadOnlyDatabase(directory)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open source directory")
+		}
+		return NewLocalSource(db), nil
+	}
+	limiter := NewRateLimiter(host.String(), requestsPerSecond, samplesPerSecond, latencyThreshold)
+	client, err := NewRemoteReadClient(name, host, httpConfig, limiter)
+	if err != nil {
+		return nil, err
+	}
+	return NewRemoteReadSource(client), nil
+}
+
+// NewRemoteReadClient creates a client for the remote read endpoint of the host.
+func NewRemoteReadClient(name string, host *url.URL, httpConfig promConfig.HTTPClientConfig, limiter RateLimiter) (ReadClient, error) {
+	readUrl, err := common.JoinUrl(host, "api/v1/read")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create remote read url")
+	}
+	client, err := NewReadClient(name, &promRemote.ClientConfig{
+		URL:              &promConfig.URL{URL: readUrl},
+		Timeout:          model.Duration(remoteReadTimeout),
+		HTTPClientConfig: httpConfig,
+		RetryOnRateLimit: true,
+	}, limiter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create remote client")
+	}
+	return client, nil
+}
+
+// NewLocalSource creates a source reading from the database, which is closed when the source is closed.
+func NewLocalSource(db database.ReadOnlyDatabase) Source {
+	return &localSource{db: db}
+}
+
+type localSource struct {
+	db database.ReadOnlyDatabase
+}
+
+func (s *localSource) Read(_ context.Context, start int64, end int64, matcherSets [][]*labels.Matcher, handler SeriesHandler) error {
+	q, err := s.db.Querier(start, end)
+	if err != nil {
+		return errors.Wrap(err, "failed to create querier")
+	}
+	defer func(q storage.Querier) {
+		_ = q.Close()
+	}(q)
+	hints := &storage.SelectHints{
+		Start: start,
+		End:   end,
+	}
+	for _, matchers := range matcherSets {
+		ss := q.Select(true, hints, matchers...)
+		for ss.Next() {
+			series := ss.At()
+			if err = handler(series.Labels(), series.Iterator()); err != nil {
+				return err
+			}
+		}
+		if err = ss.Err(); err != nil {
+			return errors.Wrap(err, "failed to select series")
+		}
+	}
+	return nil
+}
+
+func (s *localSource) Close() error {
+	return s.db.Close()
+}
+
+// NewRemoteReadSource creates a source reading from the remote read endpoint of the client.
+func NewRemoteReadSource(client ReadClient) Source {
+	return &remoteReadSource{client: client}
+}
+
+type remoteReadSource struct {
+	client ReadClient
+}
+
+func (s *remoteReadSource) Read(ctx context.Context, start int64, end int64, matcherSets [][]*labels.Matcher, handler SeriesHandler) error {
+	hints := &storage.SelectHints{
+		Start: start,
+		End:   end,
+	}
+	var queries []*prompb.Query
+	for _, matchers := range matcherSets {
+		query, err := promRemote.ToQuery(start, end, matchers, hints)
+		if err != nil {
+			return errors.Wrap(err, "failed to create remote read query")
+		}
+		queries = append(queries, query)
+	}
+	// The read is only retried until the first series is passed to the handler, as another attempt would pass the
+	// series again.
+	handled := false
+	err := backoff.Retry(func() error {
+		e := s.client.Read(ctx, queries, func(metric labels.Labels, samples SampleIterator) error {
+			handled = true
+			return handler(metric, samples)
+		})
+		if e != nil && handled {
+			return backoff.Permanent(e)
+		}
+		return e
+	}, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), maxReadRetryAttempts))
+	return errors.Wrap(err, "failed reading remote data")
+}
+
+func (s *remoteReadSource) Close() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/kadaan/promutil/lib/errors"
+	promConfig "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+	promRemote "github.com/prometheus/prometheus/storage/remote"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	remoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+	remoteWriteVersion       = "0.1.0"
+)
+
+type WriteClient interface {
+	Write(ctx context.Context, req *prompb.WriteRequest) error
+}
+
+// RecoverableError is returned by a WriteClient when the request may succeed if retried.
+type RecoverableError struct {
+	error
+	StatusCode int
+}
+
+func (e RecoverableError) Unwrap() error {
+	return e.error
+}
+
+func NewWriteClient(name string, cfg *promRemote.ClientConfig) (WriteClient, error) {
+	httpClient, err := promConfig.NewClientFromConfig(cfg.HTTPClientConfig, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create http client")
+	}
+	return &writeClient{
+		url:              cfg.URL.String(),
+		client:           httpClient,
+		timeout:          time.Duration(cfg.Timeout),
+		headers:          cfg.Headers,
+		retryOnRateLimit: cfg.RetryOnRateLimit,
+	}, nil
+}
+
+type writeClient struct {
+	url              string
+	client           *http.Client
+	timeout          time.Duration
+	headers          map[string]string
+	retryOnRateLimit bool
+}
+
+func (c *writeClient) Write(ctx context.Context, req *prompb.WriteRequest) error {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal write request")
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return errors.Wrap(err, "failed to create write request")
+	}
+	for name, value := range c.headers {
+		httpReq.Header.Set(name, value)
+	}
+	httpReq.Header.Add("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", userAgent)
+	httpReq.Header.Set(remoteWriteVersionHeader, remoteWriteVersion)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	httpResp, err := c.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return RecoverableError{error: errors.Wrap(err, "failed to send write request")}
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, httpResp.Body)
+		_ = httpResp.Body.Close()
+	}()
+
+	if httpResp.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(httpResp.Body, maxErrorMessageLength))
+	err = errors.New("remote server %s returned HTTP status %s: %s", c.url, httpResp.Status, strings.TrimSpace(string(body)))
+	if httpResp.StatusCode/100 == 5 || (c.retryOnRateLimit && httpResp.StatusCode == http.StatusTooManyRequests) {
+		return RecoverableError{error: err, StatusCode: httpResp.StatusCode}
+	}
+	return err
+}
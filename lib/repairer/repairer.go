@@ -0,0 +1,103 @@
+package repairer
+
+import (
+	"context"
+	"fmt"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/oklog/ulid"
+	"k8s.io/klog/v2"
+	"os"
+)
+
+func NewRepairer() command.Task[config.RepairConfig] {
+	return &repairer{}
+}
+
+type repairer struct {
+}
+
+func (t *repairer) Run(c *config.RepairConfig) error {
+	verifications, err := database.VerifyBlocks(c.Directory)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify blocks")
+	}
+
+	var rewritten, fixed, dropped int
+	var overlapping bool
+	for _, v := range verifications {
+		if v.ProblemCount() == 0 {
+			continue
+		}
+		for _, p := range v.Problems {
+			klog.V(1).Infof("Block %s: %s", v, p)
+		}
+		overlapping = overlapping || v.Has(database.OverlapProblem)
+		switch {
+		case v.Unreadable || v.Stats.NumSamples == 0:
+			dropped++
+			if c.DryRun {
+				klog.V(0).Infof("Would drop block %s: %s", v, reason(v))
+			} else if err = os.RemoveAll(v.Dir); err != nil {
+				return errors.Wrap(err, "failed to drop block %s", v)
+			} else {
+				klog.V(0).Infof("Dropped block %s: %s", v, reason(v))
+			}
+		case v.NeedsRewrite():
+			rewritten++
+			if c.DryRun {
+				klog.V(0).Infof("Would rewrite block %s: %s", v, reason(v))
+			} else if uid, errR := database.RebuildBlock(context.Background(), v); errR != nil {
+				return errors.Wrap(errR, "failed to rewrite block %s", v)
+			} else if uid == (ulid.ULID{}) {
+				klog.V(0).Infof("Dropped block %s: no readable samples remained", v)
+			} else {
+				klog.V(0).Infof("Rewrote block %s into %s: %s", v, uid, reason(v))
+			}
+		case v.NeedsMeta():
+			fixed++
+			if c.DryRun {
+				klog.V(0).Infof("Would fix meta.json of block %s: %s", v, reason(v))
+			} else if _, errM := database.RepairBlockMeta(v); errM != nil {
+				return errors.Wrap(errM, "failed to fix meta.json of block %s", v)
+			} else {
+				klog.V(0).Infof("Fixed meta.json of block %s: %s", v, reason(v))
+			}
+		}
+	}
+
+	if c.DryRun {
+		if overlapping {
+			klog.V(0).Infof("Would compact overlapping blocks")
+		}
+		klog.V(0).Infof("Would rewrite %d blocks, fix the meta.json of %d blocks and drop %d blocks", rewritten, fixed, dropped)
+		return nil
+	}
+
+	// Rewritten blocks and fixed time ranges can overlap other blocks, so overlaps are compacted even when none were
+	// found during verification.
+	compactions, err := database.CompactOverlappingBlocks(context.Background(), c.Directory)
+	if err != nil {
+		return errors.Wrap(err, "failed to compact overlapping blocks")
+	}
+	var merged int
+	for _, compaction := range compactions {
+		merged += len(compaction.Sources)
+	}
+	klog.V(0).Infof("Rewrote %d blocks, fixed the meta.json of %d blocks, dropped %d blocks and merged %d overlapping blocks",
+		rewritten, fixed, dropped, merged)
+	return nil
+}
+
+// reason returns the first problem of the block, and how many other problems it has.
+func reason(v *database.BlockVerification) string {
+	if len(v.Problems) == 0 {
+		return "no samples"
+	}
+	if v.ProblemCount() > 1 {
+		return fmt.Sprintf("%s (and %d more problems)", v.Problems[0], v.ProblemCount()-1)
+	}
+	return v.Problems[0].String()
+}
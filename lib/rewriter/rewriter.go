@@ -0,0 +1,127 @@
+package rewriter
+
+import (
+	"context"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+)
+
+const (
+	tmpRewriteDirSuffix = ".tmp-for-rewrite"
+)
+
+func NewRewriter() command.Task[config.RewriteConfig] {
+	return &rewriter{}
+}
+
+type rewriter struct {
+}
+
+func (t *rewriter) Run(c *config.RewriteConfig) error {
+	blockDirs, err := database.BlockDirectories(c.Directory)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := database.NewTempDirectory(c.Directory, tmpRewriteDirSuffix)
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer func(tmpDir string) {
+		_ = os.RemoveAll(tmpDir)
+	}(tmpDir)
+
+	mapping := newMapping(c.Mapping)
+	var total database.RewriteStats
+	var replaced []string
+	var removed []string
+	for _, blockDir := range blockDirs {
+		stats, errR := database.RelabelBlock(context.Background(), blockDir, tmpDir, mapping.rewrite, c.DryRun)
+		if errR != nil {
+			return errors.Wrap(errR, "failed to rewrite block %s", filepath.Base(blockDir))
+		}
+		total.Series += stats.Series
+		total.Changed += stats.Changed
+		total.Dropped += stats.Dropped
+		total.Merged += stats.Merged
+		action := "Rewrote"
+		if c.DryRun {
+			action = "Would rewrite"
+		}
+		switch {
+		case stats.Unchanged():
+			klog.V(0).Infof("Block %s is unchanged", filepath.Base(blockDir))
+			continue
+		case stats.Empty():
+			action = "Removed"
+			if c.DryRun {
+				action = "Would remove"
+			}
+			removed = append(removed, blockDir)
+		default:
+			replaced = append(replaced, blockDir)
+		}
+		klog.V(0).Infof("%s block %s: %d series, %d changed, %d dropped, %d merged", action, filepath.Base(blockDir),
+			stats.Series, stats.Changed, stats.Dropped, stats.Merged)
+	}
+	if c.DryRun {
+		klog.V(0).Infof("Would rewrite %d series in %d blocks and remove %d blocks: %d changed, %d dropped, %d merged",
+			total.Series, len(replaced), len(removed), total.Changed, total.Dropped, total.Merged)
+		return nil
+	}
+
+	// The rewritten blocks are moved in before the originals are moved out, so the data is never missing.
+	if len(replaced) > 0 {
+		if err = database.MoveBlocks(tmpDir, c.Directory); err != nil {
+			return errors.Wrap(err, "failed to replace blocks")
+		}
+	}
+	for _, blockDir := range append(replaced, removed...) {
+		if c.BackupDirectory != "" {
+			if err = database.MoveBlock(blockDir, c.BackupDirectory); err != nil {
+				return errors.Wrap(err, "failed to backup block %s", filepath.Base(blockDir))
+			}
+		} else if err = os.RemoveAll(blockDir); err != nil {
+			return errors.Wrap(err, "failed to remove block %s", filepath.Base(blockDir))
+		}
+	}
+	klog.V(0).Infof("Rewrote %d series in %d blocks and removed %d blocks: %d changed, %d dropped, %d merged",
+		total.Series, len(replaced), len(removed), total.Changed, total.Dropped, total.Merged)
+	return nil
+}
+
+type mapping struct {
+	metricRenames  map[string]string
+	labelRenames   map[string]string
+	relabelConfigs []*relabel.Config
+}
+
+func newMapping(m config.RewriteMapping) *mapping {
+	return &mapping{
+		metricRenames:  m.MetricRenames,
+		labelRenames:   m.LabelRenames,
+		relabelConfigs: m.RelabelConfigs,
+	}
+}
+
+// rewrite renames the metric and labels of the series, and then applies the relabel configs.
+func (m *mapping) rewrite(lset labels.Labels) labels.Labels {
+	builder := labels.NewBuilder(lset)
+	for _, l := range lset {
+		if l.Name == labels.MetricName {
+			if name, ok := m.metricRenames[l.Value]; ok {
+				builder.Set(labels.MetricName, name)
+			}
+		} else if name, ok := m.labelRenames[l.Name]; ok {
+			builder.Del(l.Name)
+			builder.Set(name, l.Value)
+		}
+	}
+	return relabel.Process(builder.Labels(), m.relabelConfigs...)
+}
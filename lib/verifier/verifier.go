@@ -0,0 +1,45 @@
+package verifier
+
+import (
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"k8s.io/klog/v2"
+)
+
+func NewVerifier() command.Task[config.VerifyConfig] {
+	return &verifier{}
+}
+
+type verifier struct {
+}
+
+func (t *verifier) Run(c *config.VerifyConfig) error {
+	verifications, err := database.VerifyBlocks(c.Directory)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify blocks")
+	}
+
+	var problems int
+	var brokenBlocks int
+	for _, v := range verifications {
+		if v.ProblemCount() == 0 {
+			klog.V(0).Infof("Block %s: ok", v)
+			continue
+		}
+		brokenBlocks++
+		problems += v.ProblemCount()
+		for _, p := range v.Problems {
+			klog.V(0).Infof("Block %s: %s", v, p)
+		}
+		if omitted := v.ProblemCount() - len(v.Problems); omitted > 0 {
+			klog.V(0).Infof("Block %s: %d more problems", v, omitted)
+		}
+	}
+	klog.V(0).Infof("Verified %d blocks, found %d problems in %d blocks", len(verifications), problems, brokenBlocks)
+	if brokenBlocks > 0 {
+		return errors.New("found problems in %d blocks", brokenBlocks)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package walconverter
+
+import (
+	"context"
+	"github.com/kadaan/promutil/config"
+	"github.com/kadaan/promutil/lib/block"
+	"github.com/kadaan/promutil/lib/command"
+	"github.com/kadaan/promutil/lib/common"
+	"github.com/kadaan/promutil/lib/database"
+	"github.com/kadaan/promutil/lib/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	tmpWalDirSuffix = ".tmp-for-wal"
+	walDirname      = "wal"
+)
+
+func NewWalConverter() command.Task[config.WalToBlocksConfig] {
+	return &walConverter{}
+}
+
+type walConverter struct {
+}
+
+// Run flushes the WAL of the source directory to a single block in a temporary directory, and writes its samples to
+// blocks aligned like the blocks written by prometheus.
+func (t *walConverter) Run(c *config.WalToBlocksConfig) error {
+	if c.SourceDirectory == "" {
+		return errors.New("source directory must be specified")
+	}
+	if filepath.Clean(c.SourceDirectory) == filepath.Clean(c.OutputDirectory) {
+		return errors.New("source directory must differ from output directory")
+	}
+	if _, err := os.Stat(filepath.Join(c.SourceDirectory, walDirname)); err != nil {
+		return errors.Wrap(err, "failed to find WAL in %s", c.SourceDirectory)
+	}
+	walDirectory, err := database.NewTempDirectory(c.OutputDirectory, tmpWalDirSuffix)
+	if err != nil {
+		return err
+	}
+	defer func(dir string) {
+		_ = os.RemoveAll(dir)
+	}(walDirectory)
+	if err = flushWAL(c.SourceDirectory, walDirectory); err != nil {
+		return err
+	}
+
+	db, err := database.NewReadOnlyDatabase(walDirectory)
+	if err != nil {
+		return errors.Wrap(err, "failed to open flushed WAL")
+	}
+	defer func(db database.ReadOnlyDatabase) {
+		_ = db.Close()
+	}(db)
+	if len(db.Blocks()) == 0 {
+		return errors.New("no samples newer than the blocks in the WAL of %s", c.SourceDirectory)
+	}
+	meta := db.Blocks()[0].Meta()
+	klog.V(0).Infof("Replayed %d series with %d samples from %s from the WAL", meta.Stats.NumSeries,
+		meta.Stats.NumSamples, common.FormatDateRange(meta.MinTime, meta.MaxTime-1))
+
+	plannerConfig := block.NewPlannerConfig(c.OutputDirectory, time.UnixMilli(meta.MinTime).UTC(),
+		time.UnixMilli(meta.MaxTime).UTC(), time.Millisecond, int(c.Parallelism), block.WriterOptions(c.BlockWriter))
+	generator := &planGenerator{}
+	executorCreator := &planExecutorCreator{db: db}
+	output := block.NewOutput(plannerConfig, c.RemoteWriteConfig)
+	writer := block.NewPlannedBlockWriter[planData](plannerConfig, output, generator, executorCreator)
+	return writer.Run()
+}
+
+func flushWAL(sourceDirectory string, walDirectory string) error {
+	db, err := database.NewReadOnlyDatabase(sourceDirectory)
+	if err != nil {
+		return errors.Wrap(err, "failed to open source directory")
+	}
+	defer func(db database.ReadOnlyDatabase) {
+		_ = db.Close()
+	}(db)
+	if err = os.MkdirAll(walDirectory, 0o777); err != nil {
+		return errors.Wrap(err, "failed to create directory: %s", walDirectory)
+	}
+	return db.FlushWAL(walDirectory)
+}
+
+type planData struct {
+}
+
+func (p planData) String() string {
+	return "wal"
+}
+
+type planGenerator struct {
+}
+
+func (p *planGenerator) Generate(chunkStart int64, chunkEnd int64, stepDuration int64) []block.PlanEntry[planData] {
+	return []block.PlanEntry[planData]{block.NewPlanEntry("wal-to-blocks", chunkStart, chunkEnd, stepDuration, &planData{})}
+}
+
+type planExecutorCreator struct {
+	db database.ReadOnlyDatabase
+}
+
+func (p *planExecutorCreator) Create(_ string, appender database.Appender) (block.PlanExecutor[planData], error) {
+	return &planExecutor{
+		db:       p.db,
+		appender: appender,
+	}, nil
+}
+
+type planExecutor struct {
+	db       database.ReadOnlyDatabase
+	appender database.Appender
+}
+
+func (p *planExecutor) Execute(_ context.Context, _ block.PlanLogger[planData], plan block.PlanEntry[planData]) error {
+	q, err := p.db.Querier(plan.Start(), plan.End())
+	if err != nil {
+		return errors.Wrap(err, "failed to create querier")
+	}
+	defer func(q storage.Querier) {
+		_ = q.Close()
+	}(q)
+	hints := &storage.SelectHints{
+		Start: plan.Start(),
+		End:   plan.End(),
+	}
+	ss := q.Select(false, hints, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	sample := &promql.Sample{}
+	for ss.Next() {
+		series := ss.At()
+		sample.Metric = series.Labels()
+		it := series.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if t < plan.Start() || t > plan.End() || value.IsStaleNaN(v) {
+				continue
+			}
+			sample.T = t
+			sample.V = v
+			if err = p.appender.Add(sample); err != nil {
+				return errors.Wrap(err, "failed to add sample: %s", sample)
+			}
+		}
+		if err = it.Err(); err != nil {
+			return errors.Wrap(err, "failed to read samples of %s", sample.Metric)
+		}
+	}
+	return errors.Wrap(ss.Err(), "failed to select series")
+}
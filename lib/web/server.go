@@ -69,7 +69,8 @@ type server struct {
 }
 
 func (s *server) createServer() error {
-	queryable, err := remote.NewQueryable(s.config.Host, s.config.Parallelism)
+	limiter := remote.NewRateLimiter(s.config.Host.String(), s.config.RateLimit.RequestsPerSecond, s.config.RateLimit.SamplesPerSecond, s.config.RateLimit.SlowdownLatency)
+	queryable, err := remote.NewQueryable(s.config.Host, s.config.HTTPConfig, s.config.Parallelism, limiter)
 	if err != nil {
 		return err
 	}